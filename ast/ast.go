@@ -3,14 +3,20 @@ package ast
 
 import "fmt"
 
-// Node interface represents a generic AST node
+// Node interface represents a generic AST node. Pos and EndPos come from
+// the embedded Span.
 type Node interface {
 	String() string
+	Pos() Position
+	EndPos() Position
 }
 
 // Program represents the root of the AST
 type Program struct {
+	Span
 	Statements []Statement
+	// Scope holds the global variables, once the program is resolved.
+	Scope *Scope
 }
 
 func (p *Program) String() string {
@@ -36,9 +42,15 @@ type Expression interface {
 
 // FunctionDef represents a function definition
 type FunctionDef struct {
+	Span
 	Name       string
 	Parameters []string
 	Body       []Statement
+	// Scope holds the parameters, in their first slots, and the function's
+	// own variables. Slot is where the function is stored in the scope it
+	// is defined in.
+	Scope *Scope
+	Slot  int
 }
 
 func (f *FunctionDef) String() string {
@@ -49,8 +61,11 @@ func (f *FunctionDef) statementNode() {}
 
 // FunctionCall represents a function call
 type FunctionCall struct {
+	Span
 	Name string
 	Args []Expression
+	// Binding locates the called function; it is nil for the builtins.
+	Binding *Binding
 }
 
 func (f *FunctionCall) String() string {
@@ -60,34 +75,102 @@ func (f *FunctionCall) String() string {
 func (f *FunctionCall) statementNode()   {}
 func (f *FunctionCall) expressionNode() {}
 
-// SetStatement represents a variable assignment
+// FunctionLiteral represents an anonymous function value, written either
+// as "fn x y -> x + y" or as a "func x y" ... "end" block in expression
+// position. The one-line form's body is a single return statement.
+type FunctionLiteral struct {
+	Span
+	Parameters []string
+	Body       []Statement
+	Scope      *Scope
+}
+
+func (f *FunctionLiteral) String() string {
+	return fmt.Sprintf("FunctionLiteral{Params: %v, Body: %v}", f.Parameters, f.Body)
+}
+
+func (f *FunctionLiteral) expressionNode() {}
+
+// CallExpression represents a call whose callee is itself an expression,
+// such as [[make_adder 1] 5] or [handlers{click} event]
+type CallExpression struct {
+	Span
+	Callee Expression
+	Args   []Expression
+}
+
+func (c *CallExpression) String() string {
+	return fmt.Sprintf("CallExpression{Callee: %s, Args: %v}", c.Callee.String(), c.Args)
+}
+
+func (c *CallExpression) expressionNode() {}
+
+// SetStatement represents a variable assignment. A non-empty Path assigns
+// into the array or object held by Variable, one key per level, as in
+// "set data{person}{age} to 26".
 type SetStatement struct {
+	Span
 	Variable string
+	Path     []Expression
 	Value    Expression
+	Binding  *Binding
 }
 
 func (s *SetStatement) String() string {
+	if len(s.Path) > 0 {
+		return fmt.Sprintf("SetStatement{Variable: %s, Path: %v, Value: %s}", s.Variable, s.Path, s.Value.String())
+	}
 	return fmt.Sprintf("SetStatement{Variable: %s, Value: %s}", s.Variable, s.Value.String())
 }
 
 func (s *SetStatement) statementNode() {}
 
-// LoopStatement represents a loop construct
+// LoopStatement represents a loop construct. Counter, when set, names a
+// variable holding the zero-based iteration number.
 type LoopStatement struct {
-	Count Expression
-	Body  []Statement
+	Span
+	Count   Expression
+	Counter string
+	Body    []Statement
+	// Scope holds the counter, when there is one, in slot 0.
+	Scope *Scope
 }
 
 func (l *LoopStatement) String() string {
+	if l.Counter != "" {
+		return fmt.Sprintf("LoopStatement{Count: %s, Counter: %s, Body: %v}", l.Count.String(), l.Counter, l.Body)
+	}
 	return fmt.Sprintf("LoopStatement{Count: %s, Body: %v}", l.Count.String(), l.Body)
 }
 
 func (l *LoopStatement) statementNode() {}
 
+// ForStatement represents a for-each loop over an array, object, string or
+// range. Key is only set for the two-name form "for key, value in x".
+type ForStatement struct {
+	Span
+	Key      string
+	Value    string
+	Iterable Expression
+	Body     []Statement
+	// Scope holds the loop variables first: the key, if any, then the
+	// value.
+	Scope *Scope
+}
+
+func (f *ForStatement) String() string {
+	return fmt.Sprintf("ForStatement{Key: %s, Value: %s, Iterable: %s, Body: %v}", f.Key, f.Value, f.Iterable.String(), f.Body)
+}
+
+func (f *ForStatement) statementNode() {}
+
 // WhileStatement represents a while loop
 type WhileStatement struct {
+	Span
 	Condition Expression
 	Body      []Statement
+	// Scope is shared by the condition and the body.
+	Scope *Scope
 }
 
 func (w *WhileStatement) String() string {
@@ -98,9 +181,12 @@ func (w *WhileStatement) statementNode() {}
 
 // SwitchStatement represents a switch-case construct
 type SwitchStatement struct {
+	Span
 	Expression Expression
 	Cases      []CaseClause
 	Default    []Statement
+	// Scope is shared by every case body and the default.
+	Scope *Scope
 }
 
 func (s *SwitchStatement) String() string {
@@ -111,6 +197,7 @@ func (s *SwitchStatement) statementNode() {}
 
 // CaseClause represents a case in a switch statement
 type CaseClause struct {
+	Span
 	Values []Expression
 	Body   []Statement
 }
@@ -119,13 +206,21 @@ func (c *CaseClause) String() string {
 	return fmt.Sprintf("CaseClause{Values: %v, Body: %v}", c.Values, c.Body)
 }
 
-// IfStatement represents a conditional statement
+// IfStatement represents a conditional statement. An else-if chain is
+// stored as a single nested IfStatement in Alternative.
 type IfStatement struct {
-	Condition Expression
-	Body      []Statement
+	Span
+	Condition        Expression
+	Body             []Statement
+	Alternative      []Statement
+	Scope            *Scope
+	AlternativeScope *Scope
 }
 
 func (i *IfStatement) String() string {
+	if i.Alternative != nil {
+		return fmt.Sprintf("IfStatement{Condition: %s, Body: %v, Else: %v}", i.Condition.String(), i.Body, i.Alternative)
+	}
 	return fmt.Sprintf("IfStatement{Condition: %s, Body: %v}", i.Condition.String(), i.Body)
 }
 
@@ -133,7 +228,12 @@ func (i *IfStatement) statementNode() {}
 
 // ReturnStatement represents a return statement
 type ReturnStatement struct {
+	Span
 	Value Expression
+	// Tail is set by the resolver when Value is a call that can run in
+	// place of the function returning it, as in `return [f x]` outside any
+	// try statement.
+	Tail bool
 }
 
 func (r *ReturnStatement) String() string {
@@ -142,8 +242,31 @@ func (r *ReturnStatement) String() string {
 
 func (r *ReturnStatement) statementNode() {}
 
+// BreakStatement exits the innermost enclosing loop
+type BreakStatement struct {
+	Span
+}
+
+func (b *BreakStatement) String() string {
+	return "BreakStatement{}"
+}
+
+func (b *BreakStatement) statementNode() {}
+
+// ContinueStatement skips to the next iteration of the innermost enclosing loop
+type ContinueStatement struct {
+	Span
+}
+
+func (c *ContinueStatement) String() string {
+	return "ContinueStatement{}"
+}
+
+func (c *ContinueStatement) statementNode() {}
+
 // BinaryExpression represents a binary operation
 type BinaryExpression struct {
+	Span
 	Left     Expression
 	Operator string
 	Right    Expression
@@ -155,9 +278,38 @@ func (b *BinaryExpression) String() string {
 
 func (b *BinaryExpression) expressionNode() {}
 
+// RangeExpression represents the integers from Start up to, but not
+// including, End
+type RangeExpression struct {
+	Span
+	Start Expression
+	End   Expression
+}
+
+func (r *RangeExpression) String() string {
+	return fmt.Sprintf("RangeExpression{Start: %s, End: %s}", r.Start.String(), r.End.String())
+}
+
+func (r *RangeExpression) expressionNode() {}
+
+// PrefixExpression represents a unary operation such as "not x" or "-x"
+type PrefixExpression struct {
+	Span
+	Operator string
+	Right    Expression
+}
+
+func (p *PrefixExpression) String() string {
+	return fmt.Sprintf("PrefixExpression{Op: %s, Right: %s}", p.Operator, p.Right.String())
+}
+
+func (p *PrefixExpression) expressionNode() {}
+
 // Identifier represents a variable or function name
 type Identifier struct {
-	Name string
+	Span
+	Name    string
+	Binding *Binding
 }
 
 func (i *Identifier) String() string {
@@ -168,6 +320,7 @@ func (i *Identifier) expressionNode() {}
 
 // IntegerLiteral represents an integer constant
 type IntegerLiteral struct {
+	Span
 	Value int
 }
 
@@ -177,8 +330,21 @@ func (i *IntegerLiteral) String() string {
 
 func (i *IntegerLiteral) expressionNode() {}
 
+// FloatLiteral represents a floating-point constant
+type FloatLiteral struct {
+	Span
+	Value float64
+}
+
+func (f *FloatLiteral) String() string {
+	return fmt.Sprintf("FloatLiteral{%g}", f.Value)
+}
+
+func (f *FloatLiteral) expressionNode() {}
+
 // BooleanLiteral represents a boolean constant
 type BooleanLiteral struct {
+	Span
 	Value bool
 }
 
@@ -188,26 +354,59 @@ func (b *BooleanLiteral) String() string {
 
 func (b *BooleanLiteral) expressionNode() {}
 
-// TryStatement represents a try-catch block
+// TryStatement represents a try-catch block. A raised error runs the first
+// catch clause that accepts its kind. Finally, when the statement has a
+// finally block, runs last whichever way the try and catch blocks exit;
+// it is nil otherwise.
 type TryStatement struct {
-	TryBody   []Statement
-	CatchBody []Statement
-	ErrorVar  string
+	Span
+	TryBody []Statement
+	Catches []CatchClause
+	Finally []Statement
 }
 
 func (t *TryStatement) String() string {
-	return fmt.Sprintf("TryStatement{Try: %v, Catch: %v, ErrorVar: %s}", t.TryBody, t.CatchBody, t.ErrorVar)
+	return fmt.Sprintf("TryStatement{Try: %v, Catches: %v, Finally: %v}", t.TryBody, t.Catches, t.Finally)
 }
 
 func (t *TryStatement) statementNode() {}
 
+// CatchClause represents one catch block of a try statement. Kinds lists
+// the error kinds it handles; an empty list handles every error.
+type CatchClause struct {
+	Span
+	ErrorVar string
+	Kinds    []string
+	Body     []Statement
+	// Scope holds the error variable, when there is one, in slot 0.
+	Scope *Scope
+}
+
+func (c *CatchClause) String() string {
+	return fmt.Sprintf("CatchClause{ErrorVar: %s, Kinds: %v, Body: %v}", c.ErrorVar, c.Kinds, c.Body)
+}
+
+// ThrowStatement raises Value as an error
+type ThrowStatement struct {
+	Span
+	Value Expression
+}
+
+func (t *ThrowStatement) String() string {
+	return fmt.Sprintf("ThrowStatement{Value: %s}", t.Value.String())
+}
+
+func (t *ThrowStatement) statementNode() {}
+
 // AskStatement represents a user input prompt
 type AskStatement struct {
+	Span
 	Question string
 }
 
 // StringLiteral represents a string constant
 type StringLiteral struct {
+	Span
 	Value string
 }
 
@@ -217,8 +416,23 @@ func (s *StringLiteral) String() string {
 
 func (s *StringLiteral) expressionNode() {}
 
+// InterpolatedString represents a string literal containing ${...}. Parts
+// are the literal text, as StringLiterals, and the embedded expressions, in
+// source order.
+type InterpolatedString struct {
+	Span
+	Parts []Expression
+}
+
+func (s *InterpolatedString) String() string {
+	return fmt.Sprintf("InterpolatedString{Parts: %v}", s.Parts)
+}
+
+func (s *InterpolatedString) expressionNode() {}
+
 // BracketExpression represents a parenthesized expression
 type BracketExpression struct {
+	Span
 	Expression Expression
 }
 
@@ -230,6 +444,7 @@ func (b *BracketExpression) expressionNode() {}
 
 // AccessExpression represents object property access
 type AccessExpression struct {
+	Span
 	Object Expression
 	Key    Expression
 }
@@ -242,6 +457,7 @@ func (a *AccessExpression) expressionNode() {}
 
 // ArrayLiteral represents an array of expressions
 type ArrayLiteral struct {
+	Span
 	Elements []Expression
 }
 
@@ -253,6 +469,7 @@ func (a *ArrayLiteral) expressionNode() {}
 
 // ObjectProperty represents a key-value pair in an object
 type ObjectProperty struct {
+	Span
 	Key   string
 	Value Expression
 }
@@ -263,6 +480,7 @@ func (o *ObjectProperty) String() string {
 
 // ObjectLiteral represents an object with properties
 type ObjectLiteral struct {
+	Span
 	Properties []ObjectProperty
 }
 
@@ -274,6 +492,7 @@ func (o *ObjectLiteral) expressionNode() {}
 
 // IndexExpression represents array or object indexing
 type IndexExpression struct {
+	Span
 	Object Expression
 	Index  Expression
 }
@@ -0,0 +1,44 @@
+package ast
+
+import "fmt"
+
+// Position is a place in a source file. Line and Column are 1-based and
+// Column counts runes. The zero Position means the location is unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position points at real source.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	switch {
+	case !p.IsValid():
+		return "-"
+	case p.File == "":
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Span is the stretch of source a node was parsed from. Start is its first
+// character and End is just past its last one. Every node embeds a Span, so
+// Pos and EndPos are available on all of them.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Pos returns where the node starts.
+func (s *Span) Pos() Position {
+	return s.Start
+}
+
+// EndPos returns the position just past the end of the node.
+func (s *Span) EndPos() Position {
+	return s.End
+}
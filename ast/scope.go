@@ -0,0 +1,18 @@
+package ast
+
+// Scope lists the variables a function or block can define, each at the
+// slot of its index. The resolver attaches one to every node whose body
+// runs in a scope of its own, and leaves it nil when nothing can be defined
+// there, in which case no scope is created at run time. A name listed twice,
+// as in "func f x x", refers to its last slot.
+type Scope struct {
+	Names []string
+}
+
+// Binding is where a resolved name lives: Depth scopes out from the one
+// the reference runs in, counting only scopes that exist at run time, and
+// at Slot within it.
+type Binding struct {
+	Depth int
+	Slot  int
+}
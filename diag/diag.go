@@ -0,0 +1,91 @@
+// Package diag renders errors the way a compiler does: the position, the
+// message, the offending source line with a caret under the problem, and an
+// optional hint.
+package diag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// Diagnostic is one error to show the user. Kind labels the error, as in
+// "NameError", and defaults to "error". Traceback lists the calls that led
+// to a runtime error, most recent last.
+type Diagnostic struct {
+	ast.Span
+	Kind      string
+	Message   string
+	Hint      string
+	Traceback []string
+}
+
+// Render formats d against source, the full text of the file it refers to:
+//
+//	prog.rgo:3:7: NameError: variable `countr` is not defined
+//	  3 | state countr + 1
+//	    |       ^^^^^^
+//	  hint: did you mean `counter`?
+//	  traceback (most recent call last):
+//	    prog.rgo:9:7: [report 3]
+//
+// The snippet is left out when the position is unknown or outside source.
+func Render(d Diagnostic, source string) string {
+	var b strings.Builder
+	if d.Start.IsValid() {
+		fmt.Fprintf(&b, "%s: ", d.Start)
+	}
+	kind := d.Kind
+	if kind == "" {
+		kind = "error"
+	}
+	fmt.Fprintf(&b, "%s: %s\n", kind, d.Message)
+
+	lines := strings.Split(source, "\n")
+	if d.Start.IsValid() && d.Start.Line <= len(lines) {
+		line := strings.TrimRight(lines[d.Start.Line-1], "\r")
+		number := fmt.Sprint(d.Start.Line)
+		gutter := strings.Repeat(" ", len(number))
+		fmt.Fprintf(&b, "  %s | %s\n", number, line)
+		fmt.Fprintf(&b, "  %s | %s\n", gutter, caret(line, d.Span))
+	}
+
+	if d.Hint != "" {
+		fmt.Fprintf(&b, "  hint: %s\n", d.Hint)
+	}
+	if len(d.Traceback) > 0 {
+		b.WriteString("  traceback (most recent call last):\n")
+		for _, line := range d.Traceback {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// caret draws the marker under span on line. Tabs before the span are
+// copied so the marker lines up however the terminal expands them. A span
+// running onto later lines is underlined to the end of this one.
+func caret(line string, span ast.Span) string {
+	runes := []rune(line)
+	start := min(max(span.Start.Column-1, 0), len(runes))
+	end := start + 1
+	switch {
+	case span.End.Line > span.Start.Line:
+		end = len(runes)
+	case span.End.Line == span.Start.Line && span.End.Column-1 > start:
+		end = span.End.Column - 1
+	}
+	end = max(min(end, len(runes)), start+1)
+
+	var b strings.Builder
+	for _, r := range runes[:start] {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", end-start))
+	return b.String()
+}
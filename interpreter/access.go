@@ -0,0 +1,196 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// evalIndex evaluates object{key} and object[key].
+func (i *Interpreter) evalIndex(objectExpr, keyExpr ast.Expression) (Value, *RuntimeError) {
+	object, err := i.evalExpression(objectExpr)
+	if err != nil {
+		return Nil, err
+	}
+	key, err := i.evalKey(object, keyExpr)
+	if err != nil {
+		return Nil, err
+	}
+	return i.indexValue(keyExpr, object, key)
+}
+
+// evalKey evaluates the key inside container{key}. A bare name is a
+// property name when the container is an object, so person{name} reads the
+// "name" property; anywhere else, including arr{i}, it is a variable.
+// Parenthesising the name, obj{(key)}, always reads the variable. Caught
+// errors take property names like objects, as in e{message}.
+func (i *Interpreter) evalKey(container Value, key ast.Expression) (Value, *RuntimeError) {
+	if ident, ok := key.(*ast.Identifier); ok {
+		switch container.Kind() {
+		case KindObject, KindError:
+			return StringValue(ident.Name), nil
+		case KindArray:
+			if ident.Binding == nil {
+				return Nil, i.bareKeyError(ident, ident.Name)
+			}
+		}
+	}
+	return i.evalExpression(key)
+}
+
+// bareKeyError reports a bare name used to index an array when no variable
+// has that name, so it can only have been meant as an object property.
+func (i *Interpreter) bareKeyError(node ast.Node, name string) *RuntimeError {
+	err := i.errorf(TypeError, node, "array index must be an int, not the name `%s`", name)
+	err.Hint = "a bare name is a property only on an object; `{:}` makes an empty object and `{}` an empty array"
+	return err
+}
+
+// indexValue reads object{key}. keyNode is the key expression, which
+// errors point at.
+func (i *Interpreter) indexValue(keyNode ast.Node, object, key Value) (Value, *RuntimeError) {
+	switch object.Kind() {
+	case KindArray:
+		elements, _ := object.AsArray()
+		idx, ok := key.AsInt()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "array index must be an int, not %s", key.TypeName())
+		}
+		if idx < 0 || idx >= len(elements) {
+			return Nil, i.indexError(keyNode, idx, len(elements))
+		}
+		return elements[idx], nil
+	case KindObject:
+		fields, _ := object.AsObject()
+		keyStr, ok := key.AsString()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "object key must be a string, not %s", key.TypeName())
+		}
+		return fields[keyStr], nil
+	case KindError:
+		err, _ := object.AsError()
+		keyStr, ok := key.AsString()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "error field must be a string, not %s", key.TypeName())
+		}
+		return err.field(keyStr), nil
+	}
+	return Nil, i.errorf(TypeError, keyNode, "cannot index %s", object.TypeName())
+}
+
+// assignIndex stores value at the path of keys inside container and returns
+// the updated container. Writing to index len(arr) appends; any other index
+// outside the array is an error. Every container along the path must
+// already exist.
+func (i *Interpreter) assignIndex(stmt ast.Node, container Value, keys []ast.Expression, value Value) (Value, *RuntimeError) {
+	key, err := i.evalKey(container, keys[0])
+	if err != nil {
+		return Nil, err
+	}
+	if len(keys) == 1 {
+		return i.assignLeaf(stmt, keys[0], container, key, value)
+	}
+
+	child, err := i.descend(stmt, keys[0], container, key)
+	if err != nil {
+		return Nil, err
+	}
+	if value, err = i.assignIndex(stmt, child, keys[1:], value); err != nil {
+		return Nil, err
+	}
+	return store(container, key, value), nil
+}
+
+// descend checks that container{key} can be assigned through on the way to
+// a deeper key and returns the element it holds. keyNode is the key
+// expression, which errors point at.
+func (i *Interpreter) descend(stmt, keyNode ast.Node, container, key Value) (Value, *RuntimeError) {
+	switch container.Kind() {
+	case KindArray:
+		elements, _ := container.AsArray()
+		idx, ok := key.AsInt()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "array index must be an int, not %s", key.TypeName())
+		}
+		if idx < 0 || idx >= len(elements) {
+			return Nil, i.indexError(keyNode, idx, len(elements))
+		}
+		return elements[idx], nil
+
+	case KindObject:
+		fields, _ := container.AsObject()
+		keyStr, ok := key.AsString()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "object key must be a string, not %s", key.TypeName())
+		}
+		existing, ok := fields[keyStr]
+		if !ok {
+			return Nil, i.errorf(KeyError, keyNode, "object has no key `%s`", keyStr)
+		}
+		return existing, nil
+	}
+
+	return Nil, i.errorf(TypeError, stmt, "cannot assign to %s of %s", prettyValue(key), container.TypeName())
+}
+
+// assignLeaf stores value at container{key}, the last key of a path, and
+// returns the updated container.
+func (i *Interpreter) assignLeaf(stmt, keyNode ast.Node, container, key, value Value) (Value, *RuntimeError) {
+	switch container.Kind() {
+	case KindArray:
+		elements, _ := container.AsArray()
+		idx, ok := key.AsInt()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "array index must be an int, not %s", key.TypeName())
+		}
+		if idx < 0 || idx > len(elements) {
+			err := i.indexError(keyNode, idx, len(elements))
+			err.Hint += fmt.Sprintf("; index %d appends", len(elements))
+			return Nil, err
+		}
+		if idx == len(elements) {
+			container.appendElement(value)
+			return container, nil
+		}
+		elements[idx] = value
+		return container, nil
+
+	case KindObject:
+		fields, _ := container.AsObject()
+		keyStr, ok := key.AsString()
+		if !ok {
+			return Nil, i.errorf(TypeError, keyNode, "object key must be a string, not %s", key.TypeName())
+		}
+		fields[keyStr] = value
+		return container, nil
+	}
+
+	return Nil, i.errorf(TypeError, stmt, "cannot assign to %s of %s", prettyValue(key), container.TypeName())
+}
+
+// store writes an updated element back into a container that descend has
+// already checked, returning the container.
+func store(container, key, value Value) Value {
+	switch container.Kind() {
+	case KindArray:
+		elements, _ := container.AsArray()
+		idx, _ := key.AsInt()
+		elements[idx] = value
+	case KindObject:
+		fields, _ := container.AsObject()
+		keyStr, _ := key.AsString()
+		fields[keyStr] = value
+	}
+	return container
+}
+
+// indexError reports an array index outside 0..length-1.
+func (i *Interpreter) indexError(keyNode ast.Node, idx, length int) *RuntimeError {
+	err := i.errorf(IndexError, keyNode, "array index %d out of bounds (length %d)", idx, length)
+	if length == 0 {
+		err.Hint = "the array is empty"
+	} else {
+		err.Hint = fmt.Sprintf("valid indexes are 0 to %d", length-1)
+	}
+	return err
+}
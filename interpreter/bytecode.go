@@ -0,0 +1,192 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// opcode is one VM instruction. Operands follow the opcode byte: u8 is one
+// byte and u16 two bytes, big-endian. node operands index proto.nodes and
+// name the node an error is reported against. Stack effects are written
+// [before] -> [after].
+type opcode byte
+
+const (
+	opConstant       opcode = iota // u16 constant: [] -> [value]
+	opNil                          // [] -> [nil]
+	opPop                          // [x] -> []
+	opDup                          // [x] -> [x x]
+	opGet                          // u16 ref: [] -> [value]
+	opGetKey                       // u16 ref: [container] -> [container key]
+	opSet                          // u16 ref: [value] -> []
+	opDefine                       // u16 slot: [value] -> [], in the current scope
+	opEnterScope                   // u16 scope
+	opLeaveScope                   // u8 count
+	opBinary                       // u8 operator, u16 node: [left right] -> [result]
+	opPrefix                       // u8 operator, u16 node: [right] -> [result]
+	opAnd                          // u16 target: [left] -> [], or [false] and jump
+	opOr                           // u16 target: [left] -> [], or [true] and jump
+	opTruthy                       // [x] -> [bool]
+	opCaseEqual                    // [value candidate] -> [bool]
+	opJump                         // u16 target
+	opJumpIfFalse                  // u16 target: [cond] -> []
+	opFunction                     // u16 proto: [] -> [function]
+	opLookupFunction               // u16 ref, u16 node: [] -> [function]
+	opCheckFunction                // u16 node: [callee] -> [function]
+	opCall                         // u8 argc, u16 node: [function args...] -> [result]
+	opTailCall                     // u8 argc, u16 node: [function args...] -> the current call returns the result
+	opState                        // u8 argc: [args...] -> [first arg]
+	opAsk                          // u8 has prompt: [prompt?] -> [input]
+	opReturn                       // [value] -> returns it
+	opSaveReturn                   // [value] -> [], held while finally blocks run
+	opLoadReturn                   // [] -> [the saved value]
+	opEscape                       // u8 flow, u16 node: a break or continue outside any loop
+	opArray                        // u16 count: [elements...] -> [array]
+	opObject                       // u16 keys list: [values...] -> [object]
+	opIndex                        // u16 node: [object key] -> [value]
+	opRange                        // u16 node: [start end] -> [array]
+	opInterpolate                  // u16 count: [parts...] -> [string]
+	opDescend                      // u16 key node, u16 statement node: [c k] -> [c k element]
+	opAssignPath                   // u8 depth, u16 key node, u16 statement node: [value c0 k0 ... cn kn] -> [c0]
+	opTry                          // u16 handler
+	opEndTry                       // removes the innermost handler
+	opThrow                        // u16 node: [value] -> raises
+	opMatch                        // u16 kinds list, u16 target: [error] -> [error], jumping when no kind matches
+	opIterate                      // u8 keyed, u16 node: [iterable] -> [iterator]
+	opIterateRange                 // u16 node: [start end] -> [iterator]
+	opIterateCount                 // u16 node: [count] -> [iterator]
+	opNext                         // u8 values, u16 target: [iterator] -> [iterator], [iterator value] or [iterator value key], or jump when done
+)
+
+var opcodeNames = [...]string{
+	opConstant: "CONSTANT", opNil: "NIL", opPop: "POP", opDup: "DUP",
+	opGet: "GET", opGetKey: "GET_KEY", opSet: "SET", opDefine: "DEFINE",
+	opEnterScope: "ENTER_SCOPE", opLeaveScope: "LEAVE_SCOPE",
+	opBinary: "BINARY", opPrefix: "PREFIX", opAnd: "AND", opOr: "OR",
+	opTruthy: "TRUTHY", opCaseEqual: "CASE_EQUAL", opJump: "JUMP",
+	opJumpIfFalse: "JUMP_IF_FALSE", opFunction: "FUNCTION",
+	opLookupFunction: "LOOKUP_FUNCTION", opCheckFunction: "CHECK_FUNCTION",
+	opCall: "CALL", opTailCall: "TAIL_CALL", opState: "STATE", opAsk: "ASK",
+	opReturn: "RETURN", opSaveReturn: "SAVE_RETURN", opLoadReturn: "LOAD_RETURN",
+	opEscape: "ESCAPE", opArray: "ARRAY", opObject: "OBJECT", opIndex: "INDEX",
+	opRange: "RANGE", opInterpolate: "INTERPOLATE", opDescend: "DESCEND",
+	opAssignPath: "ASSIGN_PATH", opTry: "TRY", opEndTry: "END_TRY",
+	opThrow: "THROW", opMatch: "MATCH", opIterate: "ITERATE",
+	opIterateRange: "ITERATE_RANGE", opIterateCount: "ITERATE_COUNT",
+	opNext: "NEXT",
+}
+
+// operandWidths lists the size in bytes of each opcode's operands.
+var operandWidths = [...][]int{
+	opConstant: {2}, opGet: {2}, opGetKey: {2}, opSet: {2}, opDefine: {2},
+	opEnterScope: {2}, opLeaveScope: {1}, opBinary: {1, 2}, opPrefix: {1, 2},
+	opAnd: {2}, opOr: {2}, opJump: {2}, opJumpIfFalse: {2}, opFunction: {2},
+	opLookupFunction: {2, 2}, opCheckFunction: {2}, opCall: {1, 2},
+	opTailCall: {1, 2}, opState: {1}, opAsk: {1}, opEscape: {1, 2},
+	opArray: {2}, opObject: {2}, opIndex: {2}, opRange: {2}, opInterpolate: {2}, opDescend: {2, 2},
+	opAssignPath: {1, 2, 2}, opTry: {2}, opThrow: {2}, opMatch: {2, 2},
+	opIterate: {1, 2}, opIterateRange: {2}, opIterateCount: {2}, opNext: {1, 2},
+	opReturn: nil,
+}
+
+func (op opcode) String() string {
+	if int(op) < len(opcodeNames) && opcodeNames[op] != "" {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("opcode(%d)", byte(op))
+}
+
+// operators are the binary and prefix operators, numbered for opBinary and
+// opPrefix operands.
+var operators = []string{"+", "-", "*", "/", "%", "==", "!=", "<", ">", "<=", ">=", "++", "not"}
+
+const (
+	operatorAdd = iota
+	operatorSubtract
+	operatorMultiply
+	operatorDivide
+	operatorModulo
+	operatorEqual
+	operatorNotEqual
+	operatorLess
+	operatorGreater
+	operatorLessEqual
+	operatorGreaterEqual
+)
+
+// Bytecode is a program compiled for the VM. Each top-level statement is
+// compiled on its own, the way the interpreter runs them, so a top-level
+// return ends only its own statement.
+type Bytecode struct {
+	globals    *ast.Scope
+	statements []*proto
+}
+
+// String disassembles the program, one instruction per line.
+func (b *Bytecode) String() string {
+	var out strings.Builder
+	for idx, stmt := range b.statements {
+		fmt.Fprintf(&out, "statement %d:\n", idx)
+		stmt.disassemble(&out, "  ")
+	}
+	return out.String()
+}
+
+// proto is a compiled function body or top-level statement.
+type proto struct {
+	// fn describes the function: its name and parameters for error
+	// messages and tracebacks, and the scope each call runs in. It is nil
+	// for a top-level statement, which runs in the global scope.
+	fn        *Function
+	code      []byte
+	constants []Value
+	// lists holds the keys of object literals and the kinds of catch
+	// clauses.
+	lists  [][]string
+	refs   []ref
+	scopes []*ast.Scope
+	protos []*proto
+	nodes  []ast.Node
+}
+
+func (p *proto) disassemble(out *strings.Builder, indent string) {
+	for ip := 0; ip < len(p.code); {
+		op := opcode(p.code[ip])
+		fmt.Fprintf(out, "%s%04d %s", indent, ip, op)
+		ip++
+		for _, width := range operandWidths[op] {
+			operand := int(p.code[ip])
+			if width == 2 {
+				operand = operand<<8 | int(p.code[ip+1])
+			}
+			fmt.Fprintf(out, " %d", operand)
+			ip += width
+		}
+		switch op {
+		case opConstant:
+			fmt.Fprintf(out, " (%s)", prettyValue(p.constants[p.operand(ip-2)]))
+		case opGet, opGetKey, opSet:
+			fmt.Fprintf(out, " (%s)", p.refs[p.operand(ip-2)].name)
+		}
+		out.WriteString("\n")
+	}
+	for _, nested := range p.protos {
+		fmt.Fprintf(out, "%sfunction %s:\n", indent, nested.fn.frameName())
+		nested.disassemble(out, indent+"  ")
+	}
+}
+
+// operand reads the u16 operand at ip.
+func (p *proto) operand(ip int) int {
+	return int(p.code[ip])<<8 | int(p.code[ip+1])
+}
+
+// ref is a compiled variable reference: the name, its binding, which is
+// nil when the name is not a variable, and the node an error points at.
+type ref struct {
+	name    string
+	binding *ast.Binding
+	node    ast.Node
+}
@@ -0,0 +1,673 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// Compile translates program, which must have been resolved, to bytecode
+// for the VM. It only fails when the program is too large for the
+// bytecode's fixed-width operands.
+func Compile(program *ast.Program) (*Bytecode, error) {
+	var err error
+	code := &Bytecode{globals: program.Scope}
+	for _, stmt := range program.Statements {
+		c := &compiler{proto: &proto{}, root: stmt, err: &err}
+		c.statement(stmt)
+		c.emit(opNil)
+		c.emit(opReturn)
+		code.statements = append(code.statements, c.proto)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// compiler compiles one function body or top-level statement into proto.
+type compiler struct {
+	proto *proto
+	// blocks are the constructs enclosing the code being compiled that a
+	// return, break or continue has to leave, outermost first.
+	blocks []*block
+	// root is the top-level statement being compiled; it is nil inside a
+	// function.
+	root ast.Statement
+	// err holds the first limit the program exceeded, shared by the
+	// compilers of nested functions.
+	err *error
+}
+
+type blockKind int
+
+const (
+	blockScope   blockKind = iota // a scope entered with opEnterScope
+	blockLoop                     // a loop, the target of break and continue
+	blockTry                      // a try block or catch clause with handlers to remove
+	blockPending                  // a value left on the stack while a finally block runs
+)
+
+// block is a construct a jump out of has to unwind.
+type block struct {
+	kind blockKind
+	// scoped is set for a loop that entered a scope of its own, and
+	// iterator for one that keeps an iterator on the stack.
+	scoped   bool
+	iterator bool
+	// head is where continue jumps to, and breaks lists the jump operands
+	// to patch with the loop's exit.
+	head   int
+	breaks []int
+	// handlers counts the handlers a try block installed, and finally is
+	// the block to run when leaving it.
+	handlers int
+	finally  []ast.Statement
+}
+
+func (c *compiler) fail(format string, args ...interface{}) {
+	if *c.err == nil {
+		*c.err = fmt.Errorf("program too large for the VM: "+format, args...)
+	}
+}
+
+// emit appends an instruction, encoding each operand in the width the
+// opcode declares.
+func (c *compiler) emit(op opcode, operands ...int) {
+	c.proto.code = append(c.proto.code, byte(op))
+	for idx, width := range operandWidths[op] {
+		operand := operands[idx]
+		if width == 1 {
+			if operand > math.MaxUint8 {
+				c.fail("%s operand %d exceeds 255", op, operand)
+			}
+			c.proto.code = append(c.proto.code, byte(operand))
+			continue
+		}
+		if operand > math.MaxUint16 {
+			c.fail("%s operand %d exceeds 65535", op, operand)
+		}
+		c.proto.code = append(c.proto.code, byte(operand>>8), byte(operand))
+	}
+}
+
+// emitJump emits an instruction whose last operand is a jump target still
+// to be patched, and returns where that operand is.
+func (c *compiler) emitJump(op opcode, operands ...int) int {
+	c.emit(op, append(operands, 0)...)
+	return len(c.proto.code) - 2
+}
+
+// patch points the jump operand at pos to the next instruction.
+func (c *compiler) patch(pos int) {
+	target := len(c.proto.code)
+	if target > math.MaxUint16 {
+		c.fail("jump to %d exceeds 65535", target)
+	}
+	c.proto.code[pos] = byte(target >> 8)
+	c.proto.code[pos+1] = byte(target)
+}
+
+func (c *compiler) constant(value Value) int {
+	c.proto.constants = append(c.proto.constants, value)
+	return len(c.proto.constants) - 1
+}
+
+func (c *compiler) list(names []string) int {
+	c.proto.lists = append(c.proto.lists, names)
+	return len(c.proto.lists) - 1
+}
+
+func (c *compiler) node(node ast.Node) int {
+	c.proto.nodes = append(c.proto.nodes, node)
+	return len(c.proto.nodes) - 1
+}
+
+func (c *compiler) ref(name string, binding *ast.Binding, node ast.Node) int {
+	c.proto.refs = append(c.proto.refs, ref{name: name, binding: binding, node: node})
+	return len(c.proto.refs) - 1
+}
+
+// enterScope enters scope, unless the block has none, and reports whether
+// it did.
+func (c *compiler) enterScope(scope *ast.Scope) bool {
+	if scope == nil {
+		return false
+	}
+	c.proto.scopes = append(c.proto.scopes, scope)
+	c.emit(opEnterScope, len(c.proto.scopes)-1)
+	return true
+}
+
+func (c *compiler) leaveScope(entered bool) {
+	if entered {
+		c.emit(opLeaveScope, 1)
+	}
+}
+
+// block compiles body in scope, the block's own scope.
+func (c *compiler) block(scope *ast.Scope, body []ast.Statement) {
+	entered := c.enterScope(scope)
+	if entered {
+		c.blocks = append(c.blocks, &block{kind: blockScope})
+	}
+	c.statements(body)
+	if entered {
+		c.blocks = c.blocks[:len(c.blocks)-1]
+	}
+	c.leaveScope(entered)
+}
+
+func (c *compiler) statements(body []ast.Statement) {
+	for _, stmt := range body {
+		c.statement(stmt)
+	}
+}
+
+func (c *compiler) statement(stmt ast.Statement) {
+	switch node := stmt.(type) {
+	case *ast.FunctionDef:
+		c.emit(opFunction, c.function(node.Name, node.Parameters, node.Scope, node.Body))
+		c.emit(opDefine, node.Slot)
+
+	case *ast.FunctionCall:
+		c.call(node)
+		c.emit(opPop)
+
+	case *ast.SetStatement:
+		c.set(node)
+
+	case *ast.LoopStatement:
+		c.expression(node.Count)
+		c.emit(opIterateCount, c.node(node.Count))
+		c.loop(node.Scope, node.Body, false, node.Counter != "")
+
+	case *ast.WhileStatement:
+		entered := c.enterScope(node.Scope)
+		head := len(c.proto.code)
+		c.expression(node.Condition)
+		exit := c.emitJump(opJumpIfFalse)
+		loop := &block{kind: blockLoop, scoped: entered, head: head}
+		c.loopBody(loop, node.Body)
+		c.patch(exit)
+		c.leaveScope(entered)
+
+	case *ast.ForStatement:
+		if r, ok := node.Iterable.(*ast.RangeExpression); ok {
+			c.expression(r.Start)
+			c.expression(r.End)
+			c.emit(opIterateRange, c.node(r))
+		} else {
+			c.expression(node.Iterable)
+			c.emit(opIterate, boolOperand(node.Key != ""), c.node(node.Iterable))
+		}
+		c.loop(node.Scope, node.Body, node.Key != "", true)
+
+	case *ast.SwitchStatement:
+		c.switchStatement(node)
+
+	case *ast.IfStatement:
+		c.expression(node.Condition)
+		alternative := c.emitJump(opJumpIfFalse)
+		c.block(node.Scope, node.Body)
+		end := c.emitJump(opJump)
+		c.patch(alternative)
+		c.block(node.AlternativeScope, node.Alternative)
+		c.patch(end)
+
+	case *ast.ReturnStatement:
+		if node.Tail {
+			call := tailCall(node)
+			c.emit(opTailCall, c.callee(call), c.node(call))
+			return
+		}
+		c.expression(node.Value)
+		if c.crossesFinally(0) {
+			c.emit(opSaveReturn)
+			c.unwind(0)
+			c.emit(opLoadReturn)
+		}
+		c.emit(opReturn)
+
+	case *ast.BreakStatement:
+		c.escape(node, flowBreak)
+
+	case *ast.ContinueStatement:
+		c.escape(node, flowContinue)
+
+	case *ast.TryStatement:
+		c.try(node)
+
+	case *ast.ThrowStatement:
+		c.expression(node.Value)
+		c.emit(opThrow, c.node(node))
+
+	default:
+		panic(fmt.Sprintf("unknown statement type: %T", stmt))
+	}
+}
+
+func boolOperand(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// loop compiles the body of a loop over the iterator on the stack in the
+// loop's scope, which is entered once, before the first iteration. When
+// bind is set each value is defined in the scope, in slot 0, or in slot 1
+// after its key in slot 0 when keyed; otherwise it is never produced.
+func (c *compiler) loop(scope *ast.Scope, body []ast.Statement, keyed, bind bool) {
+	entered := c.enterScope(scope)
+	head := len(c.proto.code)
+	values := 0
+	switch {
+	case keyed:
+		values = 2
+	case bind:
+		values = 1
+	}
+	exit := c.emitJump(opNext, values)
+	for slot := 0; slot < values; slot++ {
+		c.emit(opDefine, slot)
+	}
+	loop := &block{kind: blockLoop, scoped: entered, iterator: true, head: head}
+	c.loopBody(loop, body)
+	c.patch(exit)
+	c.leaveScope(entered)
+	c.emit(opPop)
+}
+
+// loopBody compiles body as the body of loop, jumping back to the loop's
+// head at the end, and patches the loop's breaks to the code after it.
+func (c *compiler) loopBody(loop *block, body []ast.Statement) {
+	c.blocks = append(c.blocks, loop)
+	c.statements(body)
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	c.emit(opJump, loop.head)
+	for _, pos := range loop.breaks {
+		c.patch(pos)
+	}
+}
+
+// escape compiles a break or continue. Outside any loop it leaves every
+// enclosing block and raises the SyntaxError the interpreter reports.
+func (c *compiler) escape(node ast.Node, kind flowKind) {
+	for idx := len(c.blocks) - 1; idx >= 0; idx-- {
+		loop := c.blocks[idx]
+		if loop.kind != blockLoop {
+			continue
+		}
+		c.unwind(idx + 1)
+		if kind == flowContinue {
+			c.emit(opJump, loop.head)
+		} else {
+			loop.breaks = append(loop.breaks, c.emitJump(opJump))
+		}
+		return
+	}
+
+	c.unwind(0)
+	if c.root != nil {
+		node = c.root
+	}
+	c.emit(opEscape, int(kind), c.node(node))
+}
+
+// crossesFinally reports whether leaving blocks[from:] runs a finally
+// block.
+func (c *compiler) crossesFinally(from int) bool {
+	for _, b := range c.blocks[from:] {
+		if b.kind == blockTry && b.finally != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// unwind emits the code that leaves blocks[from:], innermost first: it
+// leaves their scopes, drops what they keep on the stack, removes their
+// handlers and runs their finally blocks.
+func (c *compiler) unwind(from int) {
+	for idx := len(c.blocks) - 1; idx >= from; idx-- {
+		b := c.blocks[idx]
+		switch b.kind {
+		case blockScope:
+			c.emit(opLeaveScope, 1)
+		case blockLoop:
+			if b.scoped {
+				c.emit(opLeaveScope, 1)
+			}
+			if b.iterator {
+				c.emit(opPop)
+			}
+		case blockPending:
+			c.emit(opPop)
+		case blockTry:
+			for n := 0; n < b.handlers; n++ {
+				c.emit(opEndTry)
+			}
+			if b.finally != nil {
+				c.inlineFinally(idx)
+			}
+		}
+	}
+}
+
+// inlineFinally compiles the finally block of blocks[idx] where a jump
+// leaves it, as if it ran at the try statement.
+func (c *compiler) inlineFinally(idx int) {
+	blocks := c.blocks
+	c.blocks = c.blocks[:idx:idx]
+	c.statements(blocks[idx].finally)
+	c.blocks = blocks
+}
+
+func (c *compiler) set(node *ast.SetStatement) {
+	c.expression(node.Value)
+	if len(node.Path) > 0 {
+		c.emit(opGet, c.ref(node.Variable, node.Binding, node))
+		last := len(node.Path) - 1
+		for idx, key := range node.Path {
+			c.key(key)
+			if idx < last {
+				c.emit(opDescend, c.node(key), c.node(node))
+			}
+		}
+		c.emit(opAssignPath, len(node.Path), c.node(node.Path[last]), c.node(node))
+	}
+	c.emit(opSet, c.ref(node.Variable, node.Binding, node))
+}
+
+// key compiles the key of container{key}, with the container on the
+// stack. A bare name is resolved at run time, as in evalKey.
+func (c *compiler) key(key ast.Expression) {
+	if ident, ok := key.(*ast.Identifier); ok {
+		c.emit(opGetKey, c.ref(ident.Name, ident.Binding, ident))
+		return
+	}
+	c.expression(key)
+}
+
+func (c *compiler) switchStatement(node *ast.SwitchStatement) {
+	// body compiles a case body in the switch's scope, once the switch
+	// value is no longer needed.
+	body := func(stmts []ast.Statement) {
+		c.emit(opPop)
+		c.block(node.Scope, stmts)
+	}
+
+	c.expression(node.Expression)
+	var ends []int
+	for _, clause := range node.Cases {
+		var matches []int
+		for _, value := range clause.Values {
+			c.emit(opDup)
+			c.expression(value)
+			c.emit(opCaseEqual)
+			next := c.emitJump(opJumpIfFalse)
+			matches = append(matches, c.emitJump(opJump))
+			c.patch(next)
+		}
+		next := c.emitJump(opJump)
+		for _, pos := range matches {
+			c.patch(pos)
+		}
+		body(clause.Body)
+		ends = append(ends, c.emitJump(opJump))
+		c.patch(next)
+	}
+	body(node.Default)
+	for _, pos := range ends {
+		c.patch(pos)
+	}
+}
+
+// try compiles a try statement. The try block runs under a handler that
+// jumps to the catch clauses with the error on the stack. When there is a
+// finally block as well, a second handler around both catches errors the
+// catch clauses raise, or rethrow, so that finally runs before they carry
+// on. Every return, break and continue out of the statement runs a copy of
+// the finally block on the way.
+func (c *compiler) try(stmt *ast.TryStatement) {
+	hasFinally := stmt.Finally != nil
+	guarded := hasFinally && len(stmt.Catches) > 0
+	var outer int
+	if guarded {
+		outer = c.emitJump(opTry)
+	}
+	handler := c.emitJump(opTry)
+
+	handlers := 1 + boolOperand(guarded)
+	c.blocks = append(c.blocks, &block{kind: blockTry, handlers: handlers, finally: stmt.Finally})
+	c.statements(stmt.TryBody)
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	for n := 0; n < handlers; n++ {
+		c.emit(opEndTry)
+	}
+	done := []int{c.emitJump(opJump)}
+
+	c.patch(handler)
+	switch {
+	case len(stmt.Catches) == 0 && !hasFinally:
+		c.emit(opPop)
+	case len(stmt.Catches) > 0:
+		c.blocks = append(c.blocks, &block{kind: blockTry, handlers: boolOperand(guarded), finally: stmt.Finally})
+		for _, clause := range stmt.Catches {
+			next := c.emitJump(opMatch, c.list(clause.Kinds))
+			c.catch(clause)
+			if guarded {
+				c.emit(opEndTry)
+			}
+			done = append(done, c.emitJump(opJump))
+			c.patch(next)
+		}
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		c.emit(opThrow, c.node(stmt))
+	}
+
+	if hasFinally {
+		if guarded {
+			c.patch(outer)
+		}
+		c.blocks = append(c.blocks, &block{kind: blockPending})
+		c.statements(stmt.Finally)
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		c.emit(opThrow, c.node(stmt))
+	}
+
+	for _, pos := range done {
+		c.patch(pos)
+	}
+	if hasFinally {
+		c.statements(stmt.Finally)
+	}
+}
+
+// catch compiles a catch clause that matched the error on the stack.
+func (c *compiler) catch(clause ast.CatchClause) {
+	entered := c.enterScope(clause.Scope)
+	if clause.ErrorVar != "" {
+		c.emit(opDefine, 0)
+	} else {
+		c.emit(opPop)
+	}
+	if entered {
+		c.blocks = append(c.blocks, &block{kind: blockScope})
+	}
+	c.statements(clause.Body)
+	if entered {
+		c.blocks = c.blocks[:len(c.blocks)-1]
+	}
+	c.leaveScope(entered)
+}
+
+// function compiles a function body into a nested proto and returns its
+// index.
+func (c *compiler) function(name string, params []string, scope *ast.Scope, body []ast.Statement) int {
+	p := &proto{fn: &Function{Name: name, Parameters: params, Scope: scope}}
+	fc := &compiler{proto: p, err: c.err}
+	fc.statements(body)
+	fc.emit(opNil)
+	fc.emit(opReturn)
+
+	c.proto.protos = append(c.proto.protos, p)
+	return len(c.proto.protos) - 1
+}
+
+func (c *compiler) call(node *ast.FunctionCall) {
+	switch node.Name {
+	case "state":
+		if len(node.Args) == 0 {
+			c.emit(opNil)
+			return
+		}
+		for _, arg := range node.Args {
+			c.expression(arg)
+		}
+		c.emit(opState, len(node.Args))
+		return
+	case "ask":
+		if len(node.Args) > 0 {
+			c.expression(node.Args[0])
+		}
+		c.emit(opAsk, boolOperand(len(node.Args) > 0))
+		return
+	}
+
+	c.emit(opCall, c.callee(node), c.node(node))
+}
+
+// callee emits the function and arguments of a call to a raingoer
+// function, a FunctionCall or a CallExpression, and returns the number of
+// arguments.
+func (c *compiler) callee(expr ast.Expression) int {
+	var args []ast.Expression
+	switch node := expr.(type) {
+	case *ast.FunctionCall:
+		c.emit(opLookupFunction, c.ref(node.Name, node.Binding, node), c.node(node))
+		args = node.Args
+	case *ast.CallExpression:
+		c.expression(node.Callee)
+		c.emit(opCheckFunction, c.node(node.Callee))
+		args = node.Args
+	}
+	for _, arg := range args {
+		c.expression(arg)
+	}
+	return len(args)
+}
+
+func (c *compiler) expression(expr ast.Expression) {
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(opConstant, c.constant(IntValue(node.Value)))
+
+	case *ast.FloatLiteral:
+		c.emit(opConstant, c.constant(FloatValue(node.Value)))
+
+	case *ast.BooleanLiteral:
+		c.emit(opConstant, c.constant(BoolValue(node.Value)))
+
+	case *ast.StringLiteral:
+		c.emit(opConstant, c.constant(StringValue(node.Value)))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			c.expression(part)
+		}
+		c.emit(opInterpolate, len(node.Parts))
+
+	case *ast.Identifier:
+		c.emit(opGet, c.ref(node.Name, node.Binding, node))
+
+	case *ast.BinaryExpression:
+		c.expression(node.Left)
+		switch node.Operator {
+		case "and", "or":
+			op := opAnd
+			if node.Operator == "or" {
+				op = opOr
+			}
+			end := c.emitJump(op)
+			c.expression(node.Right)
+			c.emit(opTruthy)
+			c.patch(end)
+			return
+		}
+		c.expression(node.Right)
+		c.emit(opBinary, operatorIndex(node.Operator), c.node(node))
+
+	case *ast.RangeExpression:
+		c.expression(node.Start)
+		c.expression(node.End)
+		c.emit(opRange, c.node(node))
+
+	case *ast.PrefixExpression:
+		c.expression(node.Right)
+		c.emit(opPrefix, operatorIndex(node.Operator), c.node(node))
+
+	case *ast.FunctionCall:
+		c.call(node)
+
+	case *ast.CallExpression:
+		c.emit(opCall, c.callee(node), c.node(node))
+
+	case *ast.FunctionLiteral:
+		c.emit(opFunction, c.function("", node.Parameters, node.Scope, node.Body))
+
+	case *ast.BracketExpression:
+		c.expression(node.Expression)
+
+	case *ast.ArrayLiteral:
+		count := 0
+		for _, elem := range node.Elements {
+			if elem != nil {
+				c.expression(elem)
+				count++
+			}
+		}
+		c.emit(opArray, count)
+
+	case *ast.ObjectLiteral:
+		var keys []string
+		for _, prop := range node.Properties {
+			if prop.Value == nil {
+				continue
+			}
+			c.expression(prop.Value)
+			key := prop.Key
+			if strings.HasPrefix(key, "\"") && strings.HasSuffix(key, "\"") {
+				key = strings.Trim(key, "\"")
+			}
+			keys = append(keys, key)
+		}
+		c.emit(opObject, c.list(keys))
+
+	case *ast.IndexExpression:
+		c.expression(node.Object)
+		c.key(node.Index)
+		c.emit(opIndex, c.node(node.Index))
+
+	case *ast.AccessExpression:
+		c.expression(node.Object)
+		c.key(node.Key)
+		c.emit(opIndex, c.node(node.Key))
+
+	default:
+		c.emit(opNil)
+	}
+}
+
+// operatorIndex numbers an operator for opBinary and opPrefix.
+func operatorIndex(operator string) int {
+	for idx, op := range operators {
+		if op == operator {
+			return idx
+		}
+	}
+	panic(fmt.Sprintf("unknown operator: %s", operator))
+}
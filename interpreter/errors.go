@@ -0,0 +1,193 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// ErrorKind classifies a RuntimeError so scripts can tell failures apart.
+type ErrorKind string
+
+const (
+	// TypeError is an operation applied to values of the wrong type,
+	// including calling something that is not a function or calling a
+	// function with the wrong number of arguments.
+	TypeError ErrorKind = "TypeError"
+	// NameError is a reference to a variable or function that is not defined.
+	NameError ErrorKind = "NameError"
+	// IndexError is an array index outside the array.
+	IndexError ErrorKind = "IndexError"
+	// KeyError is a nested assignment through an object key that is missing.
+	KeyError ErrorKind = "KeyError"
+	// ZeroDivision is a division or modulo by zero.
+	ZeroDivision ErrorKind = "ZeroDivision"
+	// SyntaxError is a statement used where it cannot run, such as `break`
+	// outside a loop.
+	SyntaxError ErrorKind = "SyntaxError"
+	// UserError is an error raised by the program itself.
+	UserError ErrorKind = "UserError"
+	// RecursionError is a call nested deeper than the maximum call depth.
+	RecursionError ErrorKind = "RecursionError"
+)
+
+// RuntimeError is an error raised by the running program, as opposed to a
+// bug in the interpreter. The embedded Span locates the node that failed,
+// Hint, when set, suggests a fix, and Stack holds the calls that were
+// active, outermost first.
+//
+// Runtime errors are returned, never panicked: a statement that fails
+// yields a flowThrow carrying the error, which unwinds to the nearest try
+// or out of Interpret. A Go panic is always an interpreter bug and is never
+// caught by a script.
+//
+// A caught error is handed to the catch block as this same value. It prints
+// as its message and exposes e{kind}, e{message}, e{line}, e{column},
+// e{file}, e{stack} and e{value}. Value is the payload of a throw
+// statement and is nil for errors raised by the interpreter.
+type RuntimeError struct {
+	ast.Span
+	Kind    ErrorKind
+	Message string
+	Hint    string
+	Stack   []Frame
+	Value   Value
+}
+
+func (e *RuntimeError) Error() string {
+	if !e.Start.IsValid() {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Start, e.Kind, e.Message)
+}
+
+// errorf builds a RuntimeError of the given kind located at node, recording
+// the calls active at this point.
+func (i *Interpreter) errorf(kind ErrorKind, node ast.Node, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{
+		Span:    ast.Span{Start: node.Pos(), End: node.EndPos()},
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		Stack:   append([]Frame{}, i.stack...),
+	}
+}
+
+// raise turns the value of a throw statement into a RuntimeError. Throwing
+// a caught error rethrows it unchanged, keeping its kind, position and
+// stack. An object's "kind" and "message" fields, when they are strings,
+// become the error's kind and message; any other value is a UserError
+// whose message is the value as printed.
+func (i *Interpreter) raise(node ast.Node, value Value) *RuntimeError {
+	if err, ok := value.AsError(); ok {
+		return err
+	}
+
+	err := i.errorf(UserError, node, "%s", prettyValue(value))
+	err.Value = value
+	if obj, ok := value.AsObject(); ok {
+		if kind, ok := obj["kind"].AsString(); ok && kind != "" {
+			err.Kind = ErrorKind(kind)
+		}
+		if message, ok := obj["message"].AsString(); ok {
+			err.Message = message
+		}
+	}
+	return err
+}
+
+// field reads e{key} for a script holding a caught error. Keys that are not
+// error fields read from a thrown object, so a payload's own fields stay
+// reachable.
+func (e *RuntimeError) field(key string) Value {
+	switch key {
+	case "kind":
+		return StringValue(string(e.Kind))
+	case "message":
+		return StringValue(e.Message)
+	case "line":
+		return IntValue(e.Start.Line)
+	case "column":
+		return IntValue(e.Start.Column)
+	case "file":
+		return StringValue(e.Start.File)
+	case "stack":
+		frames := make([]Value, len(e.Stack))
+		for idx, frame := range e.Stack {
+			frames[idx] = frame.value()
+		}
+		return ArrayValue(frames)
+	case "value":
+		return e.Value
+	}
+	if obj, ok := e.Value.AsObject(); ok {
+		return obj[key]
+	}
+	return Nil
+}
+
+// Run executes program and returns the runtime error that stopped it, if
+// any.
+func (i *Interpreter) Run(program *ast.Program) error {
+	if _, err := i.Interpret(program); err != nil {
+		return err
+	}
+	return nil
+}
+
+// builtins are the functions every program can call without defining.
+var builtins = []string{"state", "ask"}
+
+// suggestName returns the name in visible, or among the builtins, closest
+// to name, or "" if none is close enough to be a likely typo.
+func suggestName(name string, visible []string) string {
+	candidates := append(append([]string{}, visible...), builtins...)
+	sort.Strings(candidates)
+
+	best, bestDistance := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// didYouMean adds a spelling suggestion for name to err, if there is one.
+func (i *Interpreter) didYouMean(err *RuntimeError, name string) *RuntimeError {
+	return withSuggestion(err, name, i.env.Names())
+}
+
+// withSuggestion suggests the name in visible closest to name as a fix for
+// err.
+func withSuggestion(err *RuntimeError, name string, visible []string) *RuntimeError {
+	if suggestion := suggestName(name, visible); suggestion != "" {
+		err.Hint = fmt.Sprintf("did you mean `%s`?", suggestion)
+	}
+	return err
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for x := 1; x <= len(ra); x++ {
+		curr[0] = x
+		for y := 1; y <= len(rb); y++ {
+			cost := 1
+			if ra[x-1] == rb[y-1] {
+				cost = 0
+			}
+			curr[y] = min(prev[y]+1, curr[y-1]+1, prev[y-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,45 @@
+package interpreter
+
+type flowKind int
+
+const (
+	flowNormal flowKind = iota
+	flowReturn
+	flowBreak
+	flowContinue
+	flowThrow
+	flowTailCall
+)
+
+func (k flowKind) String() string {
+	switch k {
+	case flowReturn:
+		return "return"
+	case flowBreak:
+		return "break"
+	case flowContinue:
+		return "continue"
+	case flowThrow:
+		return "throw"
+	case flowTailCall:
+		return "tail call"
+	}
+	return "normal"
+}
+
+// flow is the outcome of running a statement. Anything other than
+// flowNormal unwinds enclosing blocks until a loop or function call
+// consumes it; value carries the result of a return. A flowThrow carries
+// the raised error in err and is only consumed by a try statement. A
+// flowTailCall is a return of the call pending in Interpreter.tail, which
+// the function call it unwinds to makes in its own place.
+type flow struct {
+	kind  flowKind
+	value Value
+	err   *RuntimeError
+}
+
+// throw is the flow of a statement that raised err.
+func throw(err *RuntimeError) flow {
+	return flow{kind: flowThrow, err: err}
+}
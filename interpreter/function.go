@@ -0,0 +1,116 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// Function is a function value. Env is the scope the function was created
+// in, so a call can see the variables around its definition, and Scope is
+// the scope each call runs in, with the parameters in its first slots. A
+// function created by the VM has code in place of Body.
+type Function struct {
+	Name       string
+	Parameters []string
+	Body       []ast.Statement
+	Scope      *ast.Scope
+	Env        *Environment
+
+	code *proto
+}
+
+func (i *Interpreter) evalArguments(exprs []ast.Expression) ([]Value, *RuntimeError) {
+	args := make([]Value, len(exprs))
+	for idx, expr := range exprs {
+		value, err := i.evalExpression(expr)
+		if err != nil {
+			return nil, err
+		}
+		args[idx] = value
+	}
+	return args, nil
+}
+
+// callFunction runs fn with args. call is the calling node, which any
+// error about the call itself is reported against. A tail call the body
+// returns runs here in place of fn, so its frame replaces fn's rather than
+// going on top of it.
+func (i *Interpreter) callFunction(call ast.Node, fn *Function, args []Value) (Value, *RuntimeError) {
+	if len(args) != len(fn.Parameters) {
+		return Nil, i.arityError(call, fn, len(args))
+	}
+	if len(i.stack) >= i.MaxDepth {
+		return Nil, i.recursionError(call, fn)
+	}
+
+	i.stack = append(i.stack, Frame{})
+	for {
+		funcEnv := fn.Env.enter(fn.Scope)
+		for idx := range fn.Parameters {
+			funcEnv.Define(idx, args[idx])
+		}
+
+		i.stack[len(i.stack)-1] = Frame{Function: fn.frameName(), Call: call.Pos(), Args: args}
+		f := i.execBlock(fn.Body, funcEnv)
+		if f.kind == flowTailCall {
+			call, fn, args = i.tail.call, i.tail.fn, i.tail.args
+			i.tail = pendingCall{}
+			continue
+		}
+		i.stack = i.stack[:len(i.stack)-1]
+
+		switch f.kind {
+		case flowReturn:
+			return f.value, nil
+		case flowThrow:
+			return Nil, f.err
+		case flowBreak, flowContinue:
+			return Nil, i.errorf(SyntaxError, call, "`%s` used outside of a loop in %s", f.kind, fn.description())
+		}
+		return Nil, nil
+	}
+}
+
+// arityError reports a call to fn with the wrong number of arguments.
+func (i *Interpreter) arityError(call ast.Node, fn *Function, count int) *RuntimeError {
+	err := i.errorf(TypeError, call, "%s called with %s", fn.description(), plural(count, "argument"))
+	err.Hint = fmt.Sprintf("%s expects %s", fn.description(), plural(len(fn.Parameters), "argument"))
+	if len(fn.Parameters) > 0 {
+		err.Hint += fmt.Sprintf(": %s", strings.Join(fn.Parameters, " "))
+	}
+	return err
+}
+
+// recursionError reports a call to fn made when MaxDepth calls are already
+// in progress.
+func (i *Interpreter) recursionError(call ast.Node, fn *Function) *RuntimeError {
+	err := i.errorf(RecursionError, call, "maximum call depth of %d exceeded calling %s", i.MaxDepth, fn.description())
+	err.Hint = "a call made as `return [f ...]` runs in place of its caller and does not add to the depth"
+	return err
+}
+
+// frameName names fn in a traceback.
+func (fn *Function) frameName() string {
+	if fn.Name == "" {
+		return "<anonymous>"
+	}
+	return fn.Name
+}
+
+// description names fn for an error message.
+func (fn *Function) description() string {
+	if fn.Name == "" {
+		return "anonymous function"
+	}
+	return fmt.Sprintf("function `%s`", fn.Name)
+}
+
+// plural formats a count with a noun, as in "1 argument" or "2 arguments".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -6,467 +6,629 @@ import (
 	"github.com/mistium/raingoer/ast"
 )
 
+// Environment holds the variables of one scope at run time, each in the
+// slot the resolver gave it. A slot is unset until its variable is defined.
 type Environment struct {
-	variables map[string]interface{}
-	functions map[string]*ast.FunctionDef
-	parent    *Environment
+	scope  *ast.Scope
+	values []Value
+	parent *Environment
 }
 
-func NewEnvironment(parent *Environment) *Environment {
-	return &Environment{
-		variables: make(map[string]interface{}),
-		functions: make(map[string]*ast.FunctionDef),
-		parent:    parent,
-	}
+// unset fills a slot whose variable is not defined yet.
+var unset = Value{kind: kindUnset}
+
+func NewEnvironment(scope *ast.Scope, parent *Environment) *Environment {
+	env := &Environment{parent: parent}
+	env.resize(scope)
+	return env
 }
 
-func (env *Environment) Get(name string) (interface{}, bool) {
-	if val, ok := env.variables[name]; ok {
-		return val, true
-	}
-	if env.parent != nil {
-		return env.parent.Get(name)
+// enter returns a new environment for scope inside env, or env itself when
+// the block has no scope of its own.
+func (env *Environment) enter(scope *ast.Scope) *Environment {
+	if scope == nil {
+		return env
 	}
-	return nil, false
+	return NewEnvironment(scope, env)
 }
 
-func (env *Environment) Set(name string, value interface{}) {
-	if _, ok := env.variables[name]; ok {
-		env.variables[name] = value
+// resize makes env hold the variables of scope, keeping the values it
+// already has. The global environment is resized for each program run in
+// it.
+func (env *Environment) resize(scope *ast.Scope) {
+	if scope == nil {
 		return
 	}
-	if env.parent != nil {
-		if _, ok := env.parent.Get(name); ok {
-			env.parent.Set(name, value)
-			return
-		}
+	env.scope = scope
+	for len(env.values) < len(scope.Names) {
+		env.values = append(env.values, unset)
 	}
-	env.variables[name] = value
 }
 
-func (env *Environment) GetFunction(name string) (*ast.FunctionDef, bool) {
-	if fn, ok := env.functions[name]; ok {
-		return fn, true
+// Get reads the variable at b, reporting false when it is not defined.
+func (env *Environment) Get(b *ast.Binding) (Value, bool) {
+	if b == nil {
+		return Nil, false
+	}
+	scope := env
+	for depth := 0; depth < b.Depth; depth++ {
+		scope = scope.parent
 	}
-	if env.parent != nil {
-		return env.parent.GetFunction(name)
+	if value := scope.values[b.Slot]; value.kind != kindUnset {
+		return value, true
 	}
-	return nil, false
+	return Nil, false
+}
+
+// Set assigns the variable at b, defining it if it is not defined yet.
+func (env *Environment) Set(b *ast.Binding, value Value) {
+	scope := env
+	for depth := 0; depth < b.Depth; depth++ {
+		scope = scope.parent
+	}
+	scope.values[b.Slot] = value
+}
+
+// Define binds slot in this scope only, shadowing any outer variable.
+func (env *Environment) Define(slot int, value Value) {
+	env.values[slot] = value
 }
 
-func (env *Environment) SetFunction(name string, fn *ast.FunctionDef) {
-	env.functions[name] = fn
+// Names lists every defined name visible from env, innermost scope first.
+func (env *Environment) Names() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for scope := env; scope != nil; scope = scope.parent {
+		for slot, value := range scope.values {
+			if name := scope.scope.Names[slot]; value.kind != kindUnset && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
 }
 
+// DefaultMaxDepth is how deeply calls can nest before a RecursionError,
+// unless the interpreter or VM is given another MaxDepth.
+const DefaultMaxDepth = 10000
+
+// MaxDepthLimit is the largest MaxDepth allowed. Each call the interpreter
+// makes nests Go calls too, so deeper recursion would run out of Go stack
+// and crash instead of raising a RecursionError.
+const MaxDepthLimit = 100000
+
+// StackPerCall is the Go stack to allow per call for the interpreter to
+// reach its MaxDepth, with room for function bodies that nest several
+// blocks and long expressions.
+const StackPerCall = 64 << 10
+
 type Interpreter struct {
 	env *Environment
+	// stack holds the raingoer function calls in progress, outermost first.
+	stack []Frame
+	// MaxDepth is the most calls that can be in progress at once. Tail
+	// calls do not add to the depth.
+	MaxDepth int
+	// tail is the call a flowTailCall returns the result of.
+	tail pendingCall
+}
+
+// pendingCall is a tail call whose function and arguments are evaluated
+// but which has not run yet.
+type pendingCall struct {
+	call ast.Node
+	fn   *Function
+	args []Value
 }
 
 func New() *Interpreter {
 	return &Interpreter{
-		env: NewEnvironment(nil),
+		env:      NewEnvironment(nil, nil),
+		MaxDepth: DefaultMaxDepth,
 	}
 }
 
-func (i *Interpreter) Interpret(program *ast.Program) interface{} {
-	var result interface{}
+// Interpret runs program, which must have been resolved, and returns the
+// value of its last statement. An uncaught runtime error stops the program
+// and is returned as err. The globals are kept from one call to the next,
+// so a program can be run a statement at a time as long as each statement
+// comes with the resolved program's Scope.
+func (i *Interpreter) Interpret(program *ast.Program) (Value, *RuntimeError) {
+	var result Value
+	i.env.resize(program.Scope)
 	
 	for _, stmt := range program.Statements {
-		currentResult := i.evalStatement(stmt)
-		if currentResult != nil {
-			result = currentResult
+		f := i.evalStatement(stmt)
+		switch f.kind {
+		case flowReturn:
+			return f.value, nil
+		case flowThrow:
+			return Nil, f.err
+		case flowBreak, flowContinue:
+			return Nil, i.errorf(SyntaxError, stmt, "`%s` used outside of a loop", f.kind)
+		}
+		if !f.value.IsNil() {
+			result = f.value
 		}
 	}
 	
-	return result
+	return result, nil
 }
 
-func (i *Interpreter) evalStatement(stmt ast.Statement) interface{} {
+// execBlock runs body in env and stops at the first statement that does not
+// complete normally, handing that flow back to the enclosing construct.
+func (i *Interpreter) execBlock(body []ast.Statement, env *Environment) flow {
+	oldEnv := i.env
+	i.env = env
+	
+	for _, stmt := range body {
+		if f := i.evalStatement(stmt); f.kind != flowNormal {
+			i.env = oldEnv
+			return f
+		}
+	}
+	
+	i.env = oldEnv
+	return flow{}
+}
+
+func (i *Interpreter) evalStatement(stmt ast.Statement) flow {
 	switch node := stmt.(type) {
 	case *ast.FunctionDef:
-		i.env.SetFunction(node.Name, node)
-		return nil
+		i.env.Define(node.Slot, FunctionValue(&Function{
+			Name:       node.Name,
+			Parameters: node.Parameters,
+			Body:       node.Body,
+			Scope:      node.Scope,
+			Env:        i.env,
+		}))
+		return flow{}
 		
 	case *ast.FunctionCall:
-		return i.evalFunctionCall(node)
+		value, err := i.evalFunctionCall(node)
+		if err != nil {
+			return throw(err)
+		}
+		return flow{value: value}
 		
 	case *ast.SetStatement:
-		value := i.evalExpression(node.Value)
-		i.env.Set(node.Variable, value)
-		return nil
+		value, err := i.evalExpression(node.Value)
+		if err != nil {
+			return throw(err)
+		}
+		if len(node.Path) > 0 {
+			container, ok := i.env.Get(node.Binding)
+			if !ok {
+				return throw(i.didYouMean(i.errorf(NameError, node, "variable `%s` is not defined", node.Variable), node.Variable))
+			}
+			if value, err = i.assignIndex(node, container, node.Path, value); err != nil {
+				return throw(err)
+			}
+		}
+		i.env.Set(node.Binding, value)
+		return flow{}
 		
 	case *ast.LoopStatement:
-		count := i.evalExpression(node.Count)
-		if countInt, ok := count.(int); ok {
-			loopEnv := NewEnvironment(i.env)
-			oldEnv := i.env
-			i.env = loopEnv
-			
-			for j := 0; j < countInt; j++ {
-				for _, bodyStmt := range node.Body {
-					result := i.evalStatement(bodyStmt)
-					if _, isReturn := bodyStmt.(*ast.ReturnStatement); isReturn {
-						i.env = oldEnv
-						return result
-					}
-				}
+		count, err := i.evalExpression(node.Count)
+		if err != nil {
+			return throw(err)
+		}
+		countInt, ok := count.AsInt()
+		if !ok {
+			return throw(i.loopCountError(node.Count, count))
+		}
+		loopEnv := i.env.enter(node.Scope)
+		
+		for j := 0; j < countInt; j++ {
+			if node.Counter != "" {
+				loopEnv.Define(0, IntValue(j))
+			}
+			f := i.execBlock(node.Body, loopEnv)
+			if f.kind == flowBreak {
+				break
+			}
+			if f.kind == flowReturn || f.kind == flowThrow || f.kind == flowTailCall {
+				return f
 			}
-			
-			i.env = oldEnv
 		}
-		return nil
+		return flow{}
 		
 	case *ast.WhileStatement:
-		whileEnv := NewEnvironment(i.env)
+		whileEnv := i.env.enter(node.Scope)
 		oldEnv := i.env
-		i.env = whileEnv
 		
 		for {
-			condition := i.evalExpression(node.Condition)
-			condBool, ok := condition.(bool)
-			if !ok || !condBool {
+			i.env = whileEnv
+			condition, err := i.evalExpression(node.Condition)
+			i.env = oldEnv
+			if err != nil {
+				return throw(err)
+			}
+			if !condition.Truthy() {
 				break
 			}
 			
-			for _, bodyStmt := range node.Body {
-				result := i.evalStatement(bodyStmt)
-				if _, isReturn := bodyStmt.(*ast.ReturnStatement); isReturn {
-					i.env = oldEnv
-					return result
-				}
+			f := i.execBlock(node.Body, whileEnv)
+			if f.kind == flowBreak {
+				break
+			}
+			if f.kind == flowReturn || f.kind == flowThrow || f.kind == flowTailCall {
+				return f
 			}
 		}
 		
-		i.env = oldEnv
-		return nil
+		return flow{}
 		
-	case *ast.SwitchStatement:
-		switchValue := i.evalExpression(node.Expression)
+	case *ast.ForStatement:
+		return i.evalForStatement(node)
 		
-		switchEnv := NewEnvironment(i.env)
-		oldEnv := i.env
-		i.env = switchEnv
+	case *ast.SwitchStatement:
+		switchValue, err := i.evalExpression(node.Expression)
+		if err != nil {
+			return throw(err)
+		}
+		switchEnv := i.env.enter(node.Scope)
 		
-		matched := false
 		for _, caseClause := range node.Cases {
 			for _, caseValue := range caseClause.Values {
-				caseVal := i.evalExpression(caseValue)
-				if i.valuesEqual(switchValue, caseVal) {
-					matched = true
-					for _, bodyStmt := range caseClause.Body {
-						result := i.evalStatement(bodyStmt)
-						if _, isReturn := bodyStmt.(*ast.ReturnStatement); isReturn {
-							i.env = oldEnv
-							return result
-						}
-					}
-					break
+				value, err := i.evalExpression(caseValue)
+				if err != nil {
+					return throw(err)
 				}
-			}
-			if matched {
-				break
-			}
-		}
-		
-		// Execute default case if no case matched
-		if !matched && len(node.Default) > 0 {
-			for _, bodyStmt := range node.Default {
-				result := i.evalStatement(bodyStmt)
-				if _, isReturn := bodyStmt.(*ast.ReturnStatement); isReturn {
-					i.env = oldEnv
-					return result
+				if switchValue.Equal(value) {
+					return i.execBlock(caseClause.Body, switchEnv)
 				}
 			}
 		}
 		
-		i.env = oldEnv
-		return nil
+		// Execute default case if no case matched
+		return i.execBlock(node.Default, switchEnv)
 		
 	case *ast.IfStatement:
-		condition := i.evalExpression(node.Condition)
-		if condBool, ok := condition.(bool); ok && condBool {
-			ifEnv := NewEnvironment(i.env)
-			oldEnv := i.env
-			i.env = ifEnv
-			
-			for _, bodyStmt := range node.Body {
-				result := i.evalStatement(bodyStmt)
-				if _, isReturn := bodyStmt.(*ast.ReturnStatement); isReturn {
-					i.env = oldEnv
-					return result
-				}
-			}
-			
-			i.env = oldEnv
+		condition, err := i.evalExpression(node.Condition)
+		if err != nil {
+			return throw(err)
+		}
+		branch, scope := node.Alternative, node.AlternativeScope
+		if condition.Truthy() {
+			branch, scope = node.Body, node.Scope
 		}
-		return nil
+		if len(branch) == 0 {
+			return flow{}
+		}
+		
+		return i.execBlock(branch, i.env.enter(scope))
 		
 	case *ast.ReturnStatement:
-		return i.evalExpression(node.Value)
+		if node.Tail {
+			return i.tailCall(tailCall(node))
+		}
+		value, err := i.evalExpression(node.Value)
+		if err != nil {
+			return throw(err)
+		}
+		return flow{kind: flowReturn, value: value}
+		
+	case *ast.BreakStatement:
+		return flow{kind: flowBreak}
+		
+	case *ast.ContinueStatement:
+		return flow{kind: flowContinue}
 		
 	case *ast.TryStatement:
 		return i.evalTryStatement(node)
 		
+	case *ast.ThrowStatement:
+		value, err := i.evalExpression(node.Value)
+		if err != nil {
+			return throw(err)
+		}
+		return throw(i.raise(node, value))
+		
 	default:
 		panic(fmt.Sprintf("unknown statement type: %T", stmt))
 	}
 }
 
-func (i *Interpreter) valuesEqual(a, b interface{}) bool {
-	return a == b
+func (i *Interpreter) evalFunctionCall(call *ast.FunctionCall) (Value, *RuntimeError) {
+	if call.Name == "state" {
+		if len(call.Args) == 0 {
+			return Nil, nil
+		}
+		var result Value
+		parts := make([]string, len(call.Args))
+		for idx, arg := range call.Args {
+			value, err := i.evalExpression(arg)
+			if err != nil {
+				return Nil, err
+			}
+			if idx == 0 {
+				result = value
+			}
+			parts[idx] = prettyValue(value)
+		}
+		fmt.Printf("%s\n", strings.Join(parts, " "))
+		return result, nil
+	}
+
+	if call.Name == "ask" {
+		var prompt string
+		if len(call.Args) > 0 {
+			promptVal, err := i.evalExpression(call.Args[0])
+			if err != nil {
+				return Nil, err
+			}
+			prompt, _ = promptVal.AsString()
+		}
+		if prompt != "" {
+			fmt.Print(prompt)
+		}
+		var input string
+		fmt.Scanln(&input)
+		return StringValue(input), nil
+	}
+	
+	fn, args, err := i.evalCall(call)
+	if err != nil {
+		return Nil, err
+	}
+	return i.callFunction(call, fn, args)
 }
 
-func (i *Interpreter) evalFunctionCall(call *ast.FunctionCall) interface{} {
-	   if call.Name == "state" {
-			   if len(call.Args) > 0 {
-					   result := i.evalExpression(call.Args[0])
-					   fmt.Printf("%s\n", i.prettyValue(result))
-					   return result
-			   }
-			   return nil
-	   }
+// evalCall evaluates the function and the arguments of a call to a
+// raingoer function, a FunctionCall or a CallExpression, without making
+// the call.
+func (i *Interpreter) evalCall(expr ast.Expression) (*Function, []Value, *RuntimeError) {
+	var fn *Function
+	var args []ast.Expression
+	switch call := expr.(type) {
+	case *ast.FunctionCall:
+		value, exists := i.env.Get(call.Binding)
+		if !exists {
+			return nil, nil, i.didYouMean(i.errorf(NameError, call, "function `%s` is not defined", call.Name), call.Name)
+		}
+		var ok bool
+		if fn, ok = value.AsFunction(); !ok {
+			err := i.errorf(TypeError, call, "`%s` is not a function", call.Name)
+			err.Hint = fmt.Sprintf("`%s` holds %s", call.Name, prettyValue(value))
+			return nil, nil, err
+		}
+		args = call.Args
 
+	case *ast.CallExpression:
+		callee, err := i.evalExpression(call.Callee)
+		if err != nil {
+			return nil, nil, err
+		}
+		var ok bool
+		if fn, ok = callee.AsFunction(); !ok {
+			return nil, nil, i.errorf(TypeError, call.Callee, "%s is not a function", prettyValue(callee))
+		}
+		args = call.Args
+	}
 
+	values, err := i.evalArguments(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fn, values, nil
+}
 
-	   if call.Name == "ask" {
-			   var prompt string
-			   if len(call.Args) > 0 {
-					   promptVal := i.evalExpression(call.Args[0])
-					   prompt, _ = promptVal.(string)
-			   }
-			   if prompt != "" {
-					   fmt.Print(prompt)
-			   }
-			   var input string
-			   fmt.Scanln(&input)
-			   return input
-	   }
-	
-	fn, exists := i.env.GetFunction(call.Name)
-	if !exists {
-		panic(fmt.Sprintf("Error: Function '%s' is not defined", call.Name))
+// tailCall evaluates the call of a return in tail position and leaves it
+// pending for the function making it to run in its place. Errors in the
+// call itself, such as a wrong number of arguments, are raised here while
+// that function is still on the stack.
+func (i *Interpreter) tailCall(call ast.Expression) flow {
+	fn, args, err := i.evalCall(call)
+	if err != nil {
+		return throw(err)
 	}
-	globalEnv := i.env
-	for globalEnv.parent != nil {
-		globalEnv = globalEnv.parent
+	if len(args) != len(fn.Parameters) {
+		return throw(i.arityError(call, fn, len(args)))
+	}
+	i.tail = pendingCall{call: call, fn: fn, args: args}
+	return flow{kind: flowTailCall}
+}
+
+// evalTryStatement runs the try block and, if it raises a runtime error,
+// the first catch clause that handles the error's kind, with the error
+// bound to the clause's variable. An error no clause handles keeps
+// unwinding, except that a try with neither catch clauses nor a finally
+// block discards it. Only raised errors are caught; returns, breaks and
+// continues pass through untouched.
+//
+// The finally block runs after all of that, however the try or catch block
+// exited, and the pending flow resumes once it completes. A finally block
+// that itself returns, breaks or raises replaces the pending flow.
+func (i *Interpreter) evalTryStatement(stmt *ast.TryStatement) flow {
+	f := i.execBlock(stmt.TryBody, i.env)
+	if f.kind == flowThrow {
+		f = i.catch(stmt, f.err)
 	}
-	funcEnv := NewEnvironment(globalEnv)
-	oldEnv := i.env
-	i.env = funcEnv
 	
-	for idx, param := range fn.Parameters {
-		if idx < len(call.Args) {
-			value := i.evalExpression(call.Args[idx])
-			i.env.Set(param, value)
+	if stmt.Finally != nil {
+		if cleanup := i.execBlock(stmt.Finally, i.env); cleanup.kind != flowNormal {
+			return cleanup
 		}
 	}
+	return f
+}
+
+// catch runs the catch clause of stmt that handles err and returns its
+// flow, or rethrows err when no clause handles it.
+func (i *Interpreter) catch(stmt *ast.TryStatement, err *RuntimeError) flow {
+	if len(stmt.Catches) == 0 && stmt.Finally == nil {
+		return flow{}
+	}
 	
-	var result interface{}
-	for _, stmt := range fn.Body {
-		result = i.evalStatement(stmt)
-		if _, isReturn := stmt.(*ast.ReturnStatement); isReturn {
-			break
+	for _, clause := range stmt.Catches {
+		if !handles(clause.Kinds, err) {
+			continue
+		}
+		catchEnv := i.env.enter(clause.Scope)
+		if clause.ErrorVar != "" {
+			catchEnv.Define(0, ErrorValue(err))
 		}
+		return i.execBlock(clause.Body, catchEnv)
 	}
-	
-	i.env = oldEnv
-	return result
+	return throw(err)
 }
 
-func (i *Interpreter) evalTryStatement(stmt *ast.TryStatement) interface{} {
-	defer func() {
-		if r := recover(); r != nil {
-			if len(stmt.CatchBody) > 0 {
-				catchEnv := NewEnvironment(i.env)
-				oldEnv := i.env
-				i.env = catchEnv
-				if stmt.ErrorVar != "" {
-					errorMsg := fmt.Sprintf("%v", r)
-					i.env.Set(stmt.ErrorVar, errorMsg)
-				}
-				for _, catchStmt := range stmt.CatchBody {
-					i.evalStatement(catchStmt)
-				}
-				
-				i.env = oldEnv
-			}
-		}
-	}()
-	for _, tryStmt := range stmt.TryBody {
-		result := i.evalStatement(tryStmt)
-		if _, isReturn := tryStmt.(*ast.ReturnStatement); isReturn {
-			return result
+// handles reports whether a catch clause for kinds catches err. No kinds
+// means every error.
+func handles(kinds []string, err *RuntimeError) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, kind := range kinds {
+		if ErrorKind(kind) == err.Kind {
+			return true
 		}
 	}
-	
-	return nil
+	return false
 }
 
-func (i *Interpreter) evalExpression(expr ast.Expression) interface{} {
+func (i *Interpreter) evalExpression(expr ast.Expression) (Value, *RuntimeError) {
 	switch node := expr.(type) {
 	case *ast.IntegerLiteral:
-		return node.Value
+		return IntValue(node.Value), nil
+		
+	case *ast.FloatLiteral:
+		return FloatValue(node.Value), nil
 		
 	case *ast.BooleanLiteral:
-		return node.Value
+		return BoolValue(node.Value), nil
 		
 	case *ast.StringLiteral:
-		return node.Value
+		return StringValue(node.Value), nil
+		
+	case *ast.InterpolatedString:
+		var b strings.Builder
+		for _, part := range node.Parts {
+			value, err := i.evalExpression(part)
+			if err != nil {
+				return Nil, err
+			}
+			b.WriteString(prettyValue(value))
+		}
+		return StringValue(b.String()), nil
 		
 	case *ast.Identifier:
-		if val, ok := i.env.Get(node.Name); ok {
-			return val
+		if val, ok := i.env.Get(node.Binding); ok {
+			return val, nil
 		}
-		panic(fmt.Sprintf("Error: Variable '%s' is not defined in the current scope", node.Name))
+		return Nil, i.didYouMean(i.errorf(NameError, node, "variable `%s` is not defined", node.Name), node.Name)
 		
 
-	   case *ast.BinaryExpression:
-			   left := i.evalExpression(node.Left)
-			   right := i.evalExpression(node.Right)
-			   if leftInt, ok1 := left.(int); ok1 {
-					   if rightInt, ok2 := right.(int); ok2 {
-							   switch node.Operator {
-							   case "+":
-									   return leftInt + rightInt
-							   case "-":
-									   return leftInt - rightInt
-							   case "*":
-									   return leftInt * rightInt
-							   case "/":
-									   if rightInt == 0 {
-											   panic("division by zero")
-									   }
-									   return leftInt / rightInt
-							   case "%":
-									   if rightInt == 0 {
-											   panic("modulo by zero")
-									   }
-									   return leftInt % rightInt
-							   case "==":
-									   return leftInt == rightInt
-							   case "!=":
-									   return leftInt != rightInt
-							   case "<":
-									   return leftInt < rightInt
-							   case ">":
-									   return leftInt > rightInt
-							   case "<=":
-									   return leftInt <= rightInt
-							   case ">=":
-									   return leftInt >= rightInt
-							   }
-					   }
-			   }
-			   leftStr, leftIsStr := left.(string)
-			   rightStr, rightIsStr := right.(string)
-			   switch node.Operator {
-			   case "++":
-					   if leftIsStr && rightIsStr {
-							   return leftStr + rightStr
-					   }
-					   if leftIsStr {
-							   return leftStr + fmt.Sprintf("%v", right)
-					   }
-					   if rightIsStr {
-							   return fmt.Sprintf("%v", left) + rightStr
-					   }
-					   return fmt.Sprintf("%v%v", left, right)
-			   case "==":
-					   return fmt.Sprintf("%v", left) == fmt.Sprintf("%v", right)
-			   case "!=":
-					   return fmt.Sprintf("%v", left) != fmt.Sprintf("%v", right)
-			   }
-
-			   panic(fmt.Sprintf("cannot apply operator %s to %T and %T", node.Operator, left, right))
+	case *ast.BinaryExpression:
+		left, err := i.evalExpression(node.Left)
+		if err != nil {
+			return Nil, err
+		}
+		// The logical operators only evaluate their right side when the
+		// left side does not already decide the result.
+		switch node.Operator {
+		case "and":
+			if !left.Truthy() {
+				return BoolValue(false), nil
+			}
+			right, err := i.evalExpression(node.Right)
+			return BoolValue(right.Truthy()), err
+		case "or":
+			if left.Truthy() {
+				return BoolValue(true), nil
+			}
+			right, err := i.evalExpression(node.Right)
+			return BoolValue(right.Truthy()), err
+		}
+		right, err := i.evalExpression(node.Right)
+		if err != nil {
+			return Nil, err
+		}
+		return i.evalBinary(node, node.Operator, left, right)
+		
+	case *ast.RangeExpression:
+		start, end, err := i.rangeBounds(node)
+		if err != nil {
+			return Nil, err
+		}
+		elements := []Value{}
+		for n := start; n < end; n++ {
+			elements = append(elements, IntValue(n))
+		}
+		return ArrayValue(elements), nil
+		
+	case *ast.PrefixExpression:
+		right, err := i.evalExpression(node.Right)
+		if err != nil {
+			return Nil, err
+		}
+		return i.evalPrefix(node, node.Operator, right)
 		
 	case *ast.FunctionCall:
 		return i.evalFunctionCall(node)
 		
+	case *ast.CallExpression:
+		fn, args, err := i.evalCall(node)
+		if err != nil {
+			return Nil, err
+		}
+		return i.callFunction(node, fn, args)
+		
+	case *ast.FunctionLiteral:
+		return FunctionValue(&Function{
+			Parameters: node.Parameters,
+			Body:       node.Body,
+			Scope:      node.Scope,
+			Env:        i.env,
+		}), nil
+		
 	case *ast.BracketExpression:
 		return i.evalExpression(node.Expression)
 		
 	case *ast.ArrayLiteral:
-			   var elements []interface{}
-			   for _, elem := range node.Elements {
-					   if elem == nil {
-							   continue
-					   }
-					   value := i.evalExpression(elem)
-					   if value != nil {
-							   elements = append(elements, value)
-					   }
-			   }
-			   return elements
+		var elements []Value
+		for _, elem := range node.Elements {
+			if elem == nil {
+				continue
+			}
+			value, err := i.evalExpression(elem)
+			if err != nil {
+				return Nil, err
+			}
+			if !value.IsNil() {
+				elements = append(elements, value)
+			}
+		}
+		return ArrayValue(elements), nil
 		
 	case *ast.ObjectLiteral:
-			   obj := make(map[string]interface{})
-			   for _, prop := range node.Properties {
-					   if prop.Value == nil {
-							   continue
-					   }
-					   value := i.evalExpression(prop.Value)
-					   key := prop.Key
-					   if strings.HasPrefix(key, "\"") && strings.HasSuffix(key, "\"") {
-							   key = strings.Trim(key, "\"")
-					   }
-					   obj[key] = value
-			   }
-			   return obj
+		obj := make(map[string]Value)
+		for _, prop := range node.Properties {
+			if prop.Value == nil {
+				continue
+			}
+			value, err := i.evalExpression(prop.Value)
+			if err != nil {
+				return Nil, err
+			}
+			key := prop.Key
+			if strings.HasPrefix(key, "\"") && strings.HasSuffix(key, "\"") {
+				key = strings.Trim(key, "\"")
+			}
+			obj[key] = value
+		}
+		return ObjectValue(obj), nil
 		
 	case *ast.IndexExpression:
-			   object := i.evalExpression(node.Object)
-			   index := i.evalExpression(node.Index)
-			   if object == nil {
-					   return nil
-			   }
-			   if arr, ok := object.([]interface{}); ok {
-					   if idx, ok := index.(int); ok {
-							   if idx >= 0 && idx < len(arr) {
-									   return arr[idx]
-							   }
-							   panic(fmt.Sprintf("array index %d out of bounds", idx))
-					   }
-					   panic("array index must be an integer")
-			   }
-			   if obj, ok := object.(map[string]interface{}); ok {
-					   if key, ok := index.(string); ok {
-							   if val, exists := obj[key]; exists {
-									   return val
-							   }
-							   return nil
-					   }
-					   panic("object key must be a string")
-			   }
-			   return nil
+		return i.evalIndex(node.Object, node.Index)
 		
 	case *ast.AccessExpression:
-			   object := i.evalExpression(node.Object)
-			   key := i.evalExpression(node.Key)
-			   if object == nil {
-					   return nil
-			   }
-			   if arr, ok := object.([]interface{}); ok {
-					   if idx, ok := key.(int); ok {
-							   if idx >= 0 && idx < len(arr) {
-									   return arr[idx]
-							   }
-							   panic(fmt.Sprintf("array index %d out of bounds", idx))
-					   }
-					   panic("array index must be an integer")
-			   }
-			   if obj, ok := object.(map[string]interface{}); ok {
-					   if keyStr, ok := key.(string); ok {
-							   if val, exists := obj[keyStr]; exists {
-									   return val
-							   }
-							   return nil
-					   }
-					   panic("object key must be a string")
-			   }
-			   return nil
-		
-	   default:
-			   // Return nil for unknown expression types to avoid panic and help debug
-			   return nil
+		return i.evalIndex(node.Object, node.Key)
+		
+	default:
+		// Return nil for unknown expression types to avoid panic and help debug
+		return Nil, nil
 	}
 }
@@ -0,0 +1,119 @@
+package interpreter
+
+import (
+	"sort"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+func (i *Interpreter) rangeBounds(r *ast.RangeExpression) (int, int, *RuntimeError) {
+	startValue, err := i.evalExpression(r.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	endValue, err := i.evalExpression(r.End)
+	if err != nil {
+		return 0, 0, err
+	}
+	return i.checkRange(r, startValue, endValue)
+}
+
+// checkRange checks that the evaluated bounds of the range at node are
+// ints.
+func (i *Interpreter) checkRange(node ast.Node, startValue, endValue Value) (int, int, *RuntimeError) {
+	start, ok1 := startValue.AsInt()
+	end, ok2 := endValue.AsInt()
+	if !ok1 || !ok2 {
+		return 0, 0, i.errorf(TypeError, node, "range bounds must be ints, not %s and %s", startValue.TypeName(), endValue.TypeName())
+	}
+	return start, end, nil
+}
+
+// evalForStatement runs a for-each loop. With one name the loop variable is
+// the element (arrays, strings, ranges) or the key (objects); with two names
+// it is the index or key followed by the element. Object keys are visited in
+// sorted order. A range is counted directly rather than built as an array.
+func (i *Interpreter) evalForStatement(node *ast.ForStatement) flow {
+	forEnv := i.env.enter(node.Scope)
+	valueSlot := 0
+	if node.Key != "" {
+		valueSlot = 1
+	}
+
+	// iterate runs the body once and reports whether the loop should go on.
+	iterate := func(key, value Value) (flow, bool) {
+		if node.Key != "" {
+			forEnv.Define(0, key)
+		}
+		forEnv.Define(valueSlot, value)
+		f := i.execBlock(node.Body, forEnv)
+		switch f.kind {
+		case flowBreak:
+			return flow{}, false
+		case flowReturn, flowThrow, flowTailCall:
+			return f, false
+		}
+		return flow{}, true
+	}
+
+	if r, ok := node.Iterable.(*ast.RangeExpression); ok {
+		start, end, err := i.rangeBounds(r)
+		if err != nil {
+			return throw(err)
+		}
+		for n := start; n < end; n++ {
+			if f, more := iterate(IntValue(n-start), IntValue(n)); !more {
+				return f
+			}
+		}
+		return flow{}
+	}
+
+	value, err := i.evalExpression(node.Iterable)
+	if err != nil {
+		return throw(err)
+	}
+	switch value.Kind() {
+	case KindArray:
+		elements, _ := value.AsArray()
+		for idx, elem := range elements {
+			if f, more := iterate(IntValue(idx), elem); !more {
+				return f
+			}
+		}
+	case KindObject:
+		fields, _ := value.AsObject()
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := fields[key]
+			if node.Key == "" {
+				value = StringValue(key)
+			}
+			if f, more := iterate(StringValue(key), value); !more {
+				return f
+			}
+		}
+	case KindString:
+		text, _ := value.AsString()
+		idx := 0
+		for _, char := range text {
+			if f, more := iterate(IntValue(idx), StringValue(string(char))); !more {
+				return f
+			}
+			idx++
+		}
+	default:
+		return throw(i.errorf(TypeError, node.Iterable, "cannot iterate over %s", value.TypeName()))
+	}
+	return flow{}
+}
+
+// loopCountError reports a `loop` count that is not an int. countNode is
+// the count expression, which the error points at.
+func (i *Interpreter) loopCountError(countNode ast.Node, count Value) *RuntimeError {
+	return i.errorf(TypeError, countNode, "loop count must be an int, not %s", count.TypeName())
+}
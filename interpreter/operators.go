@@ -0,0 +1,141 @@
+package interpreter
+
+import (
+	"math"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+func (i *Interpreter) evalPrefix(node ast.Node, operator string, right Value) (Value, *RuntimeError) {
+	switch operator {
+	case "not":
+		return BoolValue(!right.Truthy()), nil
+	case "-":
+		if n, ok := right.AsInt(); ok {
+			return IntValue(-n), nil
+		}
+		if f, ok := right.AsFloat(); ok {
+			return FloatValue(-f), nil
+		}
+	}
+	return Nil, i.errorf(TypeError, node, "cannot apply `%s` to %s", operator, right.TypeName())
+}
+
+func (i *Interpreter) evalBinary(node ast.Node, operator string, left, right Value) (Value, *RuntimeError) {
+	if operator == "/" || operator == "%" {
+		_, leftIsNum := left.Number()
+		if divisor, ok := right.Number(); ok && leftIsNum && divisor == 0 {
+			if operator == "/" {
+				return Nil, i.errorf(ZeroDivision, node, "division by zero")
+			}
+			return Nil, i.errorf(ZeroDivision, node, "modulo by zero")
+		}
+	}
+
+	if leftInt, ok1 := left.AsInt(); ok1 {
+		if rightInt, ok2 := right.AsInt(); ok2 {
+			if result, ok := intOperation(operator, leftInt, rightInt); ok {
+				return result, nil
+			}
+		}
+	}
+
+	// Mixed int and float operands are promoted to float.
+	leftNum, leftIsNum := left.Number()
+	rightNum, rightIsNum := right.Number()
+	if leftIsNum && rightIsNum {
+		if result, ok := floatOperation(operator, leftNum, rightNum); ok {
+			return result, nil
+		}
+	}
+
+	switch operator {
+	case "++":
+		return StringValue(prettyValue(left) + prettyValue(right)), nil
+	case "==":
+		return BoolValue(left.Equal(right)), nil
+	case "!=":
+		return BoolValue(!left.Equal(right)), nil
+	case "<", ">", "<=", ">=":
+		if order, ok := left.Compare(right); ok {
+			return BoolValue(ordered(operator, order)), nil
+		}
+	}
+
+	err := i.errorf(TypeError, node, "cannot apply `%s` to %s and %s", operator, left.TypeName(), right.TypeName())
+	if operator == "+" && (left.Kind() == KindString || right.Kind() == KindString) {
+		err.Hint = "use `++` to join strings"
+	}
+	return Nil, err
+}
+
+// ordered applies a comparison operator to the result of Compare.
+func ordered(operator string, order int) bool {
+	switch operator {
+	case "<":
+		return order < 0
+	case ">":
+		return order > 0
+	case "<=":
+		return order <= 0
+	}
+	return order >= 0
+}
+
+// intOperation and floatOperation apply an arithmetic or comparison
+// operator. evalBinary has already rejected a zero divisor.
+func intOperation(operator string, left, right int) (Value, bool) {
+	switch operator {
+	case "+":
+		return IntValue(left + right), true
+	case "-":
+		return IntValue(left - right), true
+	case "*":
+		return IntValue(left * right), true
+	case "/":
+		return IntValue(left / right), true
+	case "%":
+		return IntValue(left % right), true
+	case "==":
+		return BoolValue(left == right), true
+	case "!=":
+		return BoolValue(left != right), true
+	case "<":
+		return BoolValue(left < right), true
+	case ">":
+		return BoolValue(left > right), true
+	case "<=":
+		return BoolValue(left <= right), true
+	case ">=":
+		return BoolValue(left >= right), true
+	}
+	return Nil, false
+}
+
+func floatOperation(operator string, left, right float64) (Value, bool) {
+	switch operator {
+	case "+":
+		return FloatValue(left + right), true
+	case "-":
+		return FloatValue(left - right), true
+	case "*":
+		return FloatValue(left * right), true
+	case "/":
+		return FloatValue(left / right), true
+	case "%":
+		return FloatValue(math.Mod(left, right)), true
+	case "==":
+		return BoolValue(left == right), true
+	case "!=":
+		return BoolValue(left != right), true
+	case "<":
+		return BoolValue(left < right), true
+	case ">":
+		return BoolValue(left > right), true
+	case "<=":
+		return BoolValue(left <= right), true
+	case ">=":
+		return BoolValue(left >= right), true
+	}
+	return Nil, false
+}
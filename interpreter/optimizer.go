@@ -0,0 +1,312 @@
+package interpreter
+
+import "github.com/mistium/raingoer/ast"
+
+// Optimize rewrites program in place so that it does less work when run,
+// without changing what it prints, returns or raises:
+//
+//   - binary and prefix expressions on constants are folded into the
+//     constant they produce, unless evaluating them would raise an error;
+//   - statements after a return, break, continue or throw in the same
+//     block are dropped, since they can never run;
+//   - if statements on a constant condition keep only the branch that
+//     runs, and while loops on a false condition are dropped;
+//   - switch statements on a constant drop the cases that cannot match,
+//     and every case after one that always does.
+//
+// Top-level statements are run one at a time, so a return at the top
+// level does not make the statements after it unreachable and they are
+// kept. Optimize works on resolved and unresolved programs alike; it
+// should run after Resolve when undefined names in code it drops are still
+// to be reported.
+func Optimize(program *ast.Program) {
+	o := &optimizer{interp: New()}
+	kept := program.Statements[:0]
+	for _, stmt := range program.Statements {
+		if stmt = o.statement(stmt); stmt != nil {
+			kept = append(kept, stmt)
+		}
+	}
+	program.Statements = kept
+}
+
+type optimizer struct {
+	// interp evaluates constant expressions, so folding follows the same
+	// rules as running them.
+	interp *Interpreter
+}
+
+// block optimizes the statements of body and drops those that cannot run.
+// A body that was not nil stays so, since a nil Finally means a try
+// statement has no finally block.
+func (o *optimizer) block(body []ast.Statement) []ast.Statement {
+	kept := body[:0]
+	for _, stmt := range body {
+		if stmt = o.statement(stmt); stmt == nil {
+			continue
+		}
+		kept = append(kept, stmt)
+		switch stmt.(type) {
+		case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement, *ast.ThrowStatement:
+			return kept
+		}
+	}
+	return kept
+}
+
+// statement optimizes stmt and returns it, or nil when it does nothing.
+func (o *optimizer) statement(stmt ast.Statement) ast.Statement {
+	switch node := stmt.(type) {
+	case *ast.FunctionDef:
+		node.Body = o.block(node.Body)
+
+	case *ast.FunctionCall:
+		o.expressions(node.Args)
+
+	case *ast.SetStatement:
+		node.Value = o.expression(node.Value)
+		o.expressions(node.Path)
+
+	case *ast.LoopStatement:
+		node.Count = o.expression(node.Count)
+		node.Body = o.block(node.Body)
+
+	case *ast.ForStatement:
+		node.Iterable = o.expression(node.Iterable)
+		node.Body = o.block(node.Body)
+
+	case *ast.WhileStatement:
+		node.Condition = o.expression(node.Condition)
+		if condition, ok := constant(node.Condition); ok && !condition.Truthy() {
+			return nil
+		}
+		node.Body = o.block(node.Body)
+
+	case *ast.SwitchStatement:
+		return o.switchStatement(node)
+
+	case *ast.IfStatement:
+		return o.ifStatement(node)
+
+	case *ast.ReturnStatement:
+		node.Value = o.expression(node.Value)
+
+	case *ast.TryStatement:
+		node.TryBody = o.block(node.TryBody)
+		for idx := range node.Catches {
+			node.Catches[idx].Body = o.block(node.Catches[idx].Body)
+		}
+		node.Finally = o.block(node.Finally)
+
+	case *ast.ThrowStatement:
+		node.Value = o.expression(node.Value)
+	}
+	return stmt
+}
+
+// ifStatement keeps only the branch that runs when the condition is a
+// constant. A false condition with an else branch becomes an if true
+// around that branch, so it keeps its scope.
+func (o *optimizer) ifStatement(node *ast.IfStatement) ast.Statement {
+	node.Condition = o.expression(node.Condition)
+	node.Body = o.block(node.Body)
+	node.Alternative = o.block(node.Alternative)
+
+	condition, ok := constant(node.Condition)
+	if !ok {
+		return node
+	}
+	if !condition.Truthy() {
+		node.Condition = &ast.BooleanLiteral{Span: span(node.Condition), Value: true}
+		node.Body, node.Scope = node.Alternative, node.AlternativeScope
+	}
+	node.Alternative, node.AlternativeScope = nil, nil
+	if len(node.Body) == 0 {
+		return nil
+	}
+	return node
+}
+
+// switchStatement drops the case values that cannot match a constant
+// subject, the cases left with none, and everything after the first value
+// that always matches.
+func (o *optimizer) switchStatement(node *ast.SwitchStatement) ast.Statement {
+	node.Expression = o.expression(node.Expression)
+	for idx := range node.Cases {
+		o.expressions(node.Cases[idx].Values)
+		node.Cases[idx].Body = o.block(node.Cases[idx].Body)
+	}
+	node.Default = o.block(node.Default)
+
+	subject, ok := constant(node.Expression)
+	if !ok {
+		return node
+	}
+	cases := node.Cases[:0]
+	for _, clause := range node.Cases {
+		values := clause.Values[:0]
+		matched := false
+		for _, value := range clause.Values {
+			if candidate, ok := constant(value); ok {
+				if !subject.Equal(candidate) {
+					continue
+				}
+				matched = true
+			}
+			values = append(values, value)
+			if matched {
+				break
+			}
+		}
+		if len(values) > 0 {
+			clause.Values = values
+			cases = append(cases, clause)
+		}
+		if matched {
+			node.Cases, node.Default = cases, nil
+			return node
+		}
+	}
+	node.Cases = cases
+	if len(node.Cases) == 0 && len(node.Default) == 0 {
+		return nil
+	}
+	return node
+}
+
+func (o *optimizer) expressions(exprs []ast.Expression) {
+	for idx, expr := range exprs {
+		if expr != nil {
+			exprs[idx] = o.expression(expr)
+		}
+	}
+}
+
+// expression optimizes expr and returns what should replace it.
+func (o *optimizer) expression(expr ast.Expression) ast.Expression {
+	switch node := expr.(type) {
+	case *ast.BinaryExpression:
+		node.Left = o.expression(node.Left)
+		node.Right = o.expression(node.Right)
+		return o.fold(node)
+
+	case *ast.PrefixExpression:
+		node.Right = o.expression(node.Right)
+		if right, ok := constant(node.Right); ok {
+			if value, err := o.interp.evalPrefix(node, node.Operator, right); err == nil {
+				return literal(node, value)
+			}
+		}
+
+	case *ast.BracketExpression:
+		node.Expression = o.expression(node.Expression)
+		if _, ok := constant(node.Expression); ok {
+			return node.Expression
+		}
+
+	case *ast.InterpolatedString:
+		o.expressions(node.Parts)
+
+	case *ast.RangeExpression:
+		node.Start = o.expression(node.Start)
+		node.End = o.expression(node.End)
+
+	case *ast.FunctionCall:
+		o.expressions(node.Args)
+
+	case *ast.CallExpression:
+		node.Callee = o.expression(node.Callee)
+		o.expressions(node.Args)
+
+	case *ast.FunctionLiteral:
+		node.Body = o.block(node.Body)
+
+	case *ast.ArrayLiteral:
+		o.expressions(node.Elements)
+
+	case *ast.ObjectLiteral:
+		for idx := range node.Properties {
+			if prop := &node.Properties[idx]; prop.Value != nil {
+				prop.Value = o.expression(prop.Value)
+			}
+		}
+
+	case *ast.IndexExpression:
+		node.Object = o.expression(node.Object)
+		node.Index = o.expression(node.Index)
+
+	case *ast.AccessExpression:
+		node.Object = o.expression(node.Object)
+		node.Key = o.expression(node.Key)
+	}
+	return expr
+}
+
+// fold evaluates a binary expression whose operands are already
+// optimized. `and` and `or` fold as soon as their left side decides the
+// result.
+func (o *optimizer) fold(node *ast.BinaryExpression) ast.Expression {
+	left, leftOK := constant(node.Left)
+	right, rightOK := constant(node.Right)
+	switch node.Operator {
+	case "and", "or":
+		if !leftOK {
+			return node
+		}
+		if left.Truthy() == (node.Operator == "or") {
+			return literal(node, BoolValue(left.Truthy()))
+		}
+		if rightOK {
+			return literal(node, BoolValue(right.Truthy()))
+		}
+		return node
+	}
+	if !leftOK || !rightOK {
+		return node
+	}
+	value, err := o.interp.evalBinary(node, node.Operator, left, right)
+	if err != nil {
+		return node
+	}
+	return literal(node, value)
+}
+
+// constant returns the value of expr when it is a literal.
+func constant(expr ast.Expression) (Value, bool) {
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		return IntValue(node.Value), true
+	case *ast.FloatLiteral:
+		return FloatValue(node.Value), true
+	case *ast.BooleanLiteral:
+		return BoolValue(node.Value), true
+	case *ast.StringLiteral:
+		return StringValue(node.Value), true
+	}
+	return Nil, false
+}
+
+// literal returns the literal for value, spanning the expression it
+// replaces so errors still point at the source it came from. Folding only
+// ever produces bools, numbers and strings.
+func literal(expr ast.Expression, value Value) ast.Expression {
+	switch value.Kind() {
+	case KindBool:
+		b, _ := value.AsBool()
+		return &ast.BooleanLiteral{Span: span(expr), Value: b}
+	case KindInt:
+		n, _ := value.AsInt()
+		return &ast.IntegerLiteral{Span: span(expr), Value: n}
+	case KindFloat:
+		f, _ := value.AsFloat()
+		return &ast.FloatLiteral{Span: span(expr), Value: f}
+	case KindString:
+		s, _ := value.AsString()
+		return &ast.StringLiteral{Span: span(expr), Value: s}
+	}
+	return expr
+}
+
+func span(node ast.Node) ast.Span {
+	return ast.Span{Start: node.Pos(), End: node.EndPos()}
+}
@@ -2,26 +2,98 @@ package interpreter
 
 import (
 	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-func (i *Interpreter) prettyValue(val interface{}) string {
-	switch v := val.(type) {
-	case nil:
+// prettyValue prints val the way `state` does. An array or object inside
+// itself prints as [...] or {...}.
+func prettyValue(val Value) string {
+	return pretty(val, nil)
+}
+
+// pretty prints val inside the containers in enclosing, the arrays and
+// objects being printed around it.
+func pretty(val Value, enclosing []uintptr) string {
+	switch val.Kind() {
+	case KindNil:
 		return "nil"
-	case []interface{}:
+	case KindBool:
+		b, _ := val.AsBool()
+		return strconv.FormatBool(b)
+	case KindInt:
+		n, _ := val.AsInt()
+		return strconv.Itoa(n)
+	case KindFloat:
+		f, _ := val.AsFloat()
+		return formatFloat(f)
+	case KindString:
+		s, _ := val.AsString()
+		return s
+	case KindFunction:
+		fn, _ := val.AsFunction()
+		if fn.Name == "" {
+			return "<function>"
+		}
+		return "<function " + fn.Name + ">"
+	case KindError:
+		err, _ := val.AsError()
+		return err.Message
+	case KindArray:
+		if slices.Contains(enclosing, val.identity()) {
+			return "[...]"
+		}
+		enclosing = append(enclosing, val.identity())
+		elements, _ := val.AsArray()
 		var out []string
-		for _, elem := range v {
-			out = append(out, i.prettyValue(elem))
+		for _, elem := range elements {
+			out = append(out, pretty(elem, enclosing))
 		}
 		return "[" + strings.Join(out, ", ") + "]"
-	case map[string]interface{}:
+	case KindObject:
+		if slices.Contains(enclosing, val.identity()) {
+			return "{...}"
+		}
+		enclosing = append(enclosing, val.identity())
+		fields, _ := val.AsObject()
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
 		var out []string
-		for k, v2 := range v {
-			out = append(out, fmt.Sprintf("%s: %s", k, i.prettyValue(v2)))
+		for _, k := range keys {
+			out = append(out, fmt.Sprintf("%s: %s", k, pretty(fields[k], enclosing)))
 		}
 		return "{" + strings.Join(out, ", ") + "}"
-	default:
-		return fmt.Sprintf("%v", v)
 	}
+	return "<" + val.TypeName() + ">"
+}
+
+// formatFloat prints whole floats with a trailing ".0" so they stay
+// distinguishable from ints, and switches to exponent form, as in 1e-7 or
+// 1.5e300, only for very large or very small magnitudes.
+func formatFloat(f float64) string {
+	abs := math.Abs(f)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		// Go writes the exponent with a sign and at least two digits, as in
+		// 1e-07 and 1e+21; print it as 1e-7 and 1e21.
+		mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+		sign := ""
+		if exponent[0] == '-' {
+			sign = "-"
+		}
+		return mantissa + "e" + sign + strings.TrimLeft(exponent[1:], "0")
+	}
+	out := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(out, ".") {
+		out += ".0"
+	}
+	return out
 }
@@ -0,0 +1,357 @@
+package interpreter
+
+import (
+	"fmt"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// Resolve binds every variable and function name in program to the slot
+// it lives in and gives each function and block the Scope it runs in, so
+// the interpreter and the VM can reach variables by index instead of by
+// name. It returns a NameError for every name that is not defined anywhere
+// it is visible, in source order; a program with errors should not be run.
+// Run, Interpret and Compile expect a resolved program.
+//
+// Scoping is lexical. A block declares the functions it defines and,
+// unless an enclosing scope already declares the name, every variable it
+// sets; a set of a name declared further out assigns to that variable. A
+// try statement's try and finally blocks belong to the surrounding block.
+// Declarations are hoisted, so a function can call one defined after it.
+// Reading a declared variable before it has been set is still a NameError,
+// raised at run time.
+//
+// Resolve also marks the returns that are tail calls: a return of a
+// bracketed call inside a function but outside any try statement, which
+// must still catch the call's errors or run its finally block after it.
+func Resolve(program *ast.Program) []*RuntimeError {
+	r := &resolver{}
+	program.Scope = r.open(nil, program.Statements)
+	r.statements(program.Statements)
+	return r.errs
+}
+
+type resolver struct {
+	// scopes are the scopes around the code being resolved, outermost
+	// first. Blocks with nothing to declare have none, as at run time.
+	scopes []*resolverScope
+	errs   []*RuntimeError
+	// tail is set where a return can be a tail call.
+	tail bool
+	// loops counts the loops around the statement being resolved inside
+	// the innermost function, which break and continue need one of.
+	loops int
+}
+
+type resolverScope struct {
+	scope *ast.Scope
+	// slots maps each name to its last slot.
+	slots map[string]int
+}
+
+func (s *resolverScope) add(name string) {
+	s.slots[name] = len(s.scope.Names)
+	s.scope.Names = append(s.scope.Names, name)
+}
+
+// open starts the scope of a block that defines names, in that order,
+// before running bodies, and returns it, or nil when nothing can be
+// defined in it. A scope that is opened must be closed.
+func (r *resolver) open(names []string, bodies ...[]ast.Statement) *ast.Scope {
+	s := &resolverScope{scope: &ast.Scope{}, slots: make(map[string]int)}
+	for _, name := range names {
+		if name != "" {
+			s.add(name)
+		}
+	}
+	for _, body := range bodies {
+		r.declare(s, body)
+	}
+	if len(s.scope.Names) == 0 {
+		return nil
+	}
+	r.scopes = append(r.scopes, s)
+	return s.scope
+}
+
+func (r *resolver) close(scope *ast.Scope) {
+	if scope != nil {
+		r.scopes = r.scopes[:len(r.scopes)-1]
+	}
+}
+
+// declare adds the names body defines to s, which is not open yet.
+func (r *resolver) declare(s *resolverScope, body []ast.Statement) {
+	for _, stmt := range body {
+		switch node := stmt.(type) {
+		case *ast.FunctionDef:
+			if _, ok := s.slots[node.Name]; !ok {
+				s.add(node.Name)
+			}
+		case *ast.SetStatement:
+			if _, ok := s.slots[node.Variable]; ok || len(node.Path) > 0 {
+				continue
+			}
+			if _, ok := r.bind(node.Variable); !ok {
+				s.add(node.Variable)
+			}
+		case *ast.TryStatement:
+			r.declare(s, node.TryBody)
+			r.declare(s, node.Finally)
+		}
+	}
+}
+
+// bind finds the innermost scope declaring name.
+func (r *resolver) bind(name string) (*ast.Binding, bool) {
+	for idx := len(r.scopes) - 1; idx >= 0; idx-- {
+		if slot, ok := r.scopes[idx].slots[name]; ok {
+			return &ast.Binding{Depth: len(r.scopes) - 1 - idx, Slot: slot}, true
+		}
+	}
+	return nil, false
+}
+
+// undefined reports a reference to name at node that nothing declares.
+func (r *resolver) undefined(node ast.Node, what, name string) {
+	err := &RuntimeError{
+		Span:    ast.Span{Start: node.Pos(), End: node.EndPos()},
+		Kind:    NameError,
+		Message: fmt.Sprintf("%s `%s` is not defined", what, name),
+	}
+	var visible []string
+	for _, s := range r.scopes {
+		visible = append(visible, s.scope.Names...)
+	}
+	r.errs = append(r.errs, withSuggestion(err, name, visible))
+}
+
+// escape reports a break or continue with no loop around it in its
+// function.
+func (r *resolver) escape(node ast.Node, keyword string) {
+	if r.loops == 0 {
+		r.errs = append(r.errs, &RuntimeError{
+			Span:    ast.Span{Start: node.Pos(), End: node.EndPos()},
+			Kind:    SyntaxError,
+			Message: fmt.Sprintf("`%s` used outside of a loop", keyword),
+		})
+	}
+}
+
+func (r *resolver) statements(body []ast.Statement) {
+	for _, stmt := range body {
+		r.statement(stmt)
+	}
+}
+
+// block resolves body in a scope of its own and returns that scope.
+func (r *resolver) block(names []string, body []ast.Statement) *ast.Scope {
+	scope := r.open(names, body)
+	r.statements(body)
+	r.close(scope)
+	return scope
+}
+
+// function resolves the body of a function in a scope of its own.
+func (r *resolver) function(params []string, body []ast.Statement) *ast.Scope {
+	tail, loops := r.tail, r.loops
+	r.tail, r.loops = true, 0
+	scope := r.block(params, body)
+	r.tail, r.loops = tail, loops
+	return scope
+}
+
+
+func (r *resolver) statement(stmt ast.Statement) {
+	switch node := stmt.(type) {
+	case *ast.FunctionDef:
+		node.Slot = r.scopes[len(r.scopes)-1].slots[node.Name]
+		node.Scope = r.function(node.Parameters, node.Body)
+
+	case *ast.FunctionCall:
+		r.call(node)
+
+	case *ast.SetStatement:
+		r.expression(node.Value)
+		binding, ok := r.bind(node.Variable)
+		if !ok {
+			r.undefined(node, "variable", node.Variable)
+		}
+		node.Binding = binding
+		for _, key := range node.Path {
+			r.key(key)
+		}
+
+	case *ast.LoopStatement:
+		r.expression(node.Count)
+		r.loops++
+		node.Scope = r.block([]string{node.Counter}, node.Body)
+		r.loops--
+
+	case *ast.ForStatement:
+		r.expression(node.Iterable)
+		r.loops++
+		node.Scope = r.block([]string{node.Key, node.Value}, node.Body)
+		r.loops--
+
+	case *ast.WhileStatement:
+		node.Scope = r.open(nil, node.Body)
+		r.expression(node.Condition)
+		r.loops++
+		r.statements(node.Body)
+		r.loops--
+		r.close(node.Scope)
+
+	case *ast.BreakStatement:
+		r.escape(node, "break")
+
+	case *ast.ContinueStatement:
+		r.escape(node, "continue")
+
+	case *ast.SwitchStatement:
+		r.expression(node.Expression)
+		var bodies [][]ast.Statement
+		for _, clause := range node.Cases {
+			for _, value := range clause.Values {
+				r.expression(value)
+			}
+			bodies = append(bodies, clause.Body)
+		}
+		node.Scope = r.open(nil, append(bodies, node.Default)...)
+		for _, clause := range node.Cases {
+			r.statements(clause.Body)
+		}
+		r.statements(node.Default)
+		r.close(node.Scope)
+
+	case *ast.IfStatement:
+		r.expression(node.Condition)
+		node.Scope = r.block(nil, node.Body)
+		node.AlternativeScope = r.block(nil, node.Alternative)
+
+	case *ast.ReturnStatement:
+		r.expression(node.Value)
+		node.Tail = r.tail && tailCall(node) != nil
+
+	case *ast.TryStatement:
+		tail := r.tail
+		r.tail = false
+		r.statements(node.TryBody)
+		for idx := range node.Catches {
+			clause := &node.Catches[idx]
+			clause.Scope = r.block([]string{clause.ErrorVar}, clause.Body)
+		}
+		r.statements(node.Finally)
+		r.tail = tail
+
+	case *ast.ThrowStatement:
+		r.expression(node.Value)
+	}
+}
+
+// tailCall returns the call whose result node returns, as in
+// `return [f x]`, or nil when it returns anything else. Builtins are never
+// tail called.
+func tailCall(node *ast.ReturnStatement) ast.Expression {
+	bracket, ok := node.Value.(*ast.BracketExpression)
+	if !ok {
+		return nil
+	}
+	switch call := bracket.Expression.(type) {
+	case *ast.FunctionCall:
+		if call.Name != "state" && call.Name != "ask" {
+			return call
+		}
+	case *ast.CallExpression:
+		return call
+	}
+	return nil
+}
+
+func (r *resolver) call(node *ast.FunctionCall) {
+	if node.Name != "state" && node.Name != "ask" {
+		binding, ok := r.bind(node.Name)
+		if !ok {
+			r.undefined(node, "function", node.Name)
+		}
+		node.Binding = binding
+	}
+	for _, arg := range node.Args {
+		r.expression(arg)
+	}
+}
+
+// key resolves the key of container{key}. A bare name there may be a
+// property name rather than a variable, so it is bound when a variable is
+// visible but not reported otherwise.
+func (r *resolver) key(key ast.Expression) {
+	if ident, ok := key.(*ast.Identifier); ok {
+		ident.Binding, _ = r.bind(ident.Name)
+		return
+	}
+	r.expression(key)
+}
+
+func (r *resolver) expression(expr ast.Expression) {
+	switch node := expr.(type) {
+	case *ast.Identifier:
+		binding, ok := r.bind(node.Name)
+		if !ok {
+			r.undefined(node, "variable", node.Name)
+		}
+		node.Binding = binding
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.expression(part)
+		}
+
+	case *ast.BinaryExpression:
+		r.expression(node.Left)
+		r.expression(node.Right)
+
+	case *ast.RangeExpression:
+		r.expression(node.Start)
+		r.expression(node.End)
+
+	case *ast.PrefixExpression:
+		r.expression(node.Right)
+
+	case *ast.FunctionCall:
+		r.call(node)
+
+	case *ast.CallExpression:
+		r.expression(node.Callee)
+		for _, arg := range node.Args {
+			r.expression(arg)
+		}
+
+	case *ast.FunctionLiteral:
+		node.Scope = r.function(node.Parameters, node.Body)
+
+	case *ast.BracketExpression:
+		r.expression(node.Expression)
+
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			if elem != nil {
+				r.expression(elem)
+			}
+		}
+
+	case *ast.ObjectLiteral:
+		for _, prop := range node.Properties {
+			if prop.Value != nil {
+				r.expression(prop.Value)
+			}
+		}
+
+	case *ast.IndexExpression:
+		r.expression(node.Object)
+		r.key(node.Index)
+
+	case *ast.AccessExpression:
+		r.expression(node.Object)
+		r.key(node.Key)
+	}
+}
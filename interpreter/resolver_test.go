@@ -0,0 +1,52 @@
+package interpreter
+
+import (
+	"testing"
+
+	"github.com/mistium/raingoer/parser"
+)
+
+func TestResolveReportsEscapesOutsideLoops(t *testing.T) {
+	source := `func f
+  if true
+    break
+  end
+end
+loop 2
+  set g to func
+    continue
+  end
+  try
+    break
+  finally
+    continue
+  end
+end
+switch 1
+  case 1
+    break
+end
+`
+	program, errs := parser.NewLineParser("test.rgo", source).Parse()
+	if len(errs) > 0 {
+		t.Fatalf("parse error: %v", errs[0])
+	}
+	want := []struct {
+		line, column int
+		message      string
+	}{
+		{3, 5, "`break` used outside of a loop"},
+		{8, 5, "`continue` used outside of a loop"},
+		{18, 5, "`break` used outside of a loop"},
+	}
+	got := Resolve(program)
+	if len(got) != len(want) {
+		t.Fatalf("got %d errors %v, want %d", len(got), got, len(want))
+	}
+	for idx, err := range got {
+		w := want[idx]
+		if err.Kind != SyntaxError || err.Span.Start.Line != w.line || err.Span.Start.Column != w.column || err.Message != w.message {
+			t.Errorf("error %d = %s at %d:%d, want SyntaxError %s at %d:%d", idx, err.Message, err.Span.Start.Line, err.Span.Start.Column, w.message, w.line, w.column)
+		}
+	}
+}
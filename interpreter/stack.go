@@ -0,0 +1,73 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// Frame is one active call of a raingoer function.
+type Frame struct {
+	// Function is the called function's name, or "<anonymous>".
+	Function string
+	// Call is where the call was made from.
+	Call ast.Position
+	Args []Value
+}
+
+// String renders the frame as the call it records, as in "[fib 2]".
+func (f Frame) String() string {
+	parts := []string{f.Function}
+	for _, arg := range f.Args {
+		parts = append(parts, abbreviate(prettyValue(arg)))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// value exposes the frame to scripts as an object.
+func (f Frame) value() Value {
+	args := make([]Value, len(f.Args))
+	copy(args, f.Args)
+	return ObjectValue(map[string]Value{
+		"function": StringValue(f.Function),
+		"file":     StringValue(f.Call.File),
+		"line":     IntValue(f.Call.Line),
+		"column":   IntValue(f.Call.Column),
+		"args":     ArrayValue(args),
+	})
+}
+
+// abbreviate shortens long argument values in tracebacks.
+func abbreviate(s string) string {
+	const limit = 40
+	if runes := []rune(s); len(runes) > limit {
+		return string(runes[:limit-3]) + "..."
+	}
+	return s
+}
+
+// tracebackEnds is how many of the outermost and of the innermost calls a
+// traceback shows when the stack is deep.
+const tracebackEnds = 10
+
+// Traceback lists the calls that were active when the error was raised,
+// most recent last, one line each. A deep stack is cut to its outermost
+// and innermost calls, with a line counting the ones left out.
+func (e *RuntimeError) Traceback() []string {
+	outer, inner := e.Stack, []Frame(nil)
+	if len(e.Stack) > 2*tracebackEnds+1 {
+		outer, inner = e.Stack[:tracebackEnds], e.Stack[len(e.Stack)-tracebackEnds:]
+	}
+	var lines []string
+	for _, frame := range outer {
+		lines = append(lines, fmt.Sprintf("%s: %s", frame.Call, frame))
+	}
+	if inner != nil {
+		lines = append(lines, fmt.Sprintf("... %d more calls", len(e.Stack)-2*tracebackEnds))
+	}
+	for _, frame := range inner {
+		lines = append(lines, fmt.Sprintf("%s: %s", frame.Call, frame))
+	}
+	return lines
+}
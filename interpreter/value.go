@@ -0,0 +1,384 @@
+package interpreter
+
+import (
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Kind is the type of a Value.
+type Kind uint8
+
+const (
+	KindNil Kind = iota
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+	KindArray
+	KindObject
+	KindFunction
+	KindError
+
+	// kindUnset marks an environment slot whose variable is not defined
+	// yet, and kindIterator is a VM loop's iterator on the operand stack.
+	// Programs never see either.
+	kindUnset
+	kindIterator
+)
+
+var kindNames = [...]string{
+	KindNil:      "nil",
+	KindBool:     "bool",
+	KindInt:      "int",
+	KindFloat:    "float",
+	KindString:   "string",
+	KindArray:    "array",
+	KindObject:   "object",
+	KindFunction: "function",
+	KindError:    "error",
+	kindUnset:    "unset",
+	kindIterator: "iterator",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Value is a raingoer runtime value. Bools, ints and floats are kept in num,
+// so making one never allocates; strings, arrays, objects, functions and
+// errors are kept in ref. The zero Value is nil.
+//
+// Arrays and objects are shared, not copied: assigning one to another
+// variable or passing it to a function gives access to the same elements.
+// An array is kept behind a pointer so that appending to it is seen
+// through every copy too.
+type Value struct {
+	kind Kind
+	num  uint64
+	ref  interface{}
+}
+
+// Nil is the nil value.
+var Nil Value
+
+func BoolValue(b bool) Value {
+	if b {
+		return Value{kind: KindBool, num: 1}
+	}
+	return Value{kind: KindBool}
+}
+
+func IntValue(n int) Value {
+	return Value{kind: KindInt, num: uint64(n)}
+}
+
+func FloatValue(f float64) Value {
+	return Value{kind: KindFloat, num: math.Float64bits(f)}
+}
+
+func StringValue(s string) Value {
+	return Value{kind: KindString, ref: s}
+}
+
+func ArrayValue(elements []Value) Value {
+	return Value{kind: KindArray, ref: &elements}
+}
+
+func ObjectValue(fields map[string]Value) Value {
+	return Value{kind: KindObject, ref: fields}
+}
+
+func FunctionValue(fn *Function) Value {
+	return Value{kind: KindFunction, ref: fn}
+}
+
+func ErrorValue(err *RuntimeError) Value {
+	return Value{kind: KindError, ref: err}
+}
+
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+func (v Value) IsNil() bool {
+	return v.kind == KindNil
+}
+
+// The As methods return what v holds and whether it is of that kind.
+
+func (v Value) AsBool() (bool, bool) {
+	return v.num != 0, v.kind == KindBool
+}
+
+func (v Value) AsInt() (int, bool) {
+	return int(v.num), v.kind == KindInt
+}
+
+func (v Value) AsFloat() (float64, bool) {
+	return math.Float64frombits(v.num), v.kind == KindFloat
+}
+
+func (v Value) AsString() (string, bool) {
+	s, ok := v.ref.(string)
+	return s, ok && v.kind == KindString
+}
+
+func (v Value) AsArray() ([]Value, bool) {
+	elements, ok := v.ref.(*[]Value)
+	if !ok || v.kind != KindArray {
+		return nil, false
+	}
+	return *elements, true
+}
+
+// appendElement adds value to the end of the array v holds.
+func (v Value) appendElement(value Value) {
+	elements := v.ref.(*[]Value)
+	*elements = append(*elements, value)
+}
+
+func (v Value) AsObject() (map[string]Value, bool) {
+	fields, ok := v.ref.(map[string]Value)
+	return fields, ok && v.kind == KindObject
+}
+
+func (v Value) AsFunction() (*Function, bool) {
+	fn, ok := v.ref.(*Function)
+	return fn, ok && v.kind == KindFunction
+}
+
+func (v Value) AsError() (*RuntimeError, bool) {
+	err, ok := v.ref.(*RuntimeError)
+	return err, ok && v.kind == KindError
+}
+
+// Number reports whether v is an int or a float and, if so, its value as a
+// float64.
+func (v Value) Number() (float64, bool) {
+	switch v.kind {
+	case KindInt:
+		return float64(int(v.num)), true
+	case KindFloat:
+		return math.Float64frombits(v.num), true
+	}
+	return 0, false
+}
+
+// Truthy decides how v behaves as a condition: nil, false, zero, the empty
+// string and empty arrays and objects are false.
+func (v Value) Truthy() bool {
+	switch v.kind {
+	case KindNil:
+		return false
+	case KindBool, KindInt:
+		return v.num != 0
+	case KindFloat:
+		return math.Float64frombits(v.num) != 0
+	case KindString:
+		return v.ref.(string) != ""
+	case KindArray:
+		return len(*v.ref.(*[]Value)) > 0
+	case KindObject:
+		return len(v.ref.(map[string]Value)) > 0
+	}
+	return true
+}
+
+// Equal reports whether v and w are the same value. Numbers are equal when
+// their values are, whether int or float, and arrays and objects when
+// their elements are; functions and errors are only equal to themselves.
+// Values of any other differing kinds are never equal.
+//
+// An array or object can hold itself, so comparing two of them can come
+// back to a pair already being compared. That pair counts as equal, and
+// the result rests on the elements outside the cycle.
+func (v Value) Equal(w Value) bool {
+	return v.equal(w, nil)
+}
+
+// equal compares v and w inside the pairs of containers in seen, which are
+// the ones being compared on the way to them.
+func (v Value) equal(w Value, seen [][2]uintptr) bool {
+	if v.kind == KindInt && w.kind == KindInt {
+		return v.num == w.num
+	}
+	if a, ok := v.Number(); ok {
+		b, ok := w.Number()
+		return ok && a == b
+	}
+	if v.kind != w.kind {
+		return false
+	}
+	switch v.kind {
+	case KindNil:
+		return true
+	case KindBool:
+		return v.num == w.num
+	case KindString:
+		return v.ref.(string) == w.ref.(string)
+	case KindArray:
+		pair := [2]uintptr{v.identity(), w.identity()}
+		if pair[0] == pair[1] || slices.Contains(seen, pair) {
+			return true
+		}
+		a, b := *v.ref.(*[]Value), *w.ref.(*[]Value)
+		if len(a) != len(b) {
+			return false
+		}
+		seen = append(seen, pair)
+		for idx := range a {
+			if !a[idx].equal(b[idx], seen) {
+				return false
+			}
+		}
+		return true
+	case KindObject:
+		pair := [2]uintptr{v.identity(), w.identity()}
+		if pair[0] == pair[1] || slices.Contains(seen, pair) {
+			return true
+		}
+		a, b := v.ref.(map[string]Value), w.ref.(map[string]Value)
+		if len(a) != len(b) {
+			return false
+		}
+		seen = append(seen, pair)
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !value.equal(other, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.ref == w.ref
+}
+
+// identity tells arrays and objects apart from copies of them: two values
+// with the same identity share their elements.
+func (v Value) identity() uintptr {
+	return reflect.ValueOf(v.ref).Pointer()
+}
+
+// Compare orders v against w, returning a negative number, zero or a
+// positive number as v is less than, equal to or greater than w. Numbers
+// are ordered by value and strings byte by byte; no other values have an
+// order, which Compare reports as false.
+func (v Value) Compare(w Value) (int, bool) {
+	if v.kind == KindInt && w.kind == KindInt {
+		a, b := int(v.num), int(w.num)
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+	if a, ok := v.Number(); ok {
+		b, ok := w.Number()
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+	if v.kind == KindString && w.kind == KindString {
+		return strings.Compare(v.ref.(string), w.ref.(string)), true
+	}
+	return 0, false
+}
+
+const (
+	hashOffset = 14695981039346656037
+	hashPrime  = 1099511628211
+)
+
+// Hash returns a hash of v such that equal values hash alike, so that v
+// can key a Go map through its hash. Functions and errors, which are
+// compared by identity, hash by name and message.
+//
+// Arrays and objects nested more than hashDepth deep hash by kind alone.
+// Equal values agree to any depth, so this keeps them hashing alike even
+// when they hold themselves, and it is where a cycle stops.
+func (v Value) Hash() uint64 {
+	return v.hash(hashOffset, 0)
+}
+
+// hashDepth is how many levels of arrays and objects Hash looks into.
+const hashDepth = 8
+
+func (v Value) hash(h uint64, depth int) uint64 {
+	switch v.kind {
+	case KindInt, KindFloat:
+		// Equal compares an int with a float as float64s, so every number
+		// hashes as the float64 it converts to, with -0 as 0.
+		f, _ := v.Number()
+		if f == 0 {
+			f = 0
+		}
+		return hashWord(h, uint64(KindFloat), math.Float64bits(f))
+	case KindString:
+		return hashString(hashWord(h, uint64(KindString), 0), v.ref.(string))
+	case KindArray:
+		if depth == hashDepth {
+			return hashWord(h, uint64(KindArray), 0)
+		}
+		elements := *v.ref.(*[]Value)
+		h = hashWord(h, uint64(KindArray), uint64(len(elements)))
+		for _, elem := range elements {
+			h = elem.hash(h, depth+1)
+		}
+		return h
+	case KindObject:
+		// Objects have no order, so their fields' hashes are combined in a
+		// way that does not depend on it.
+		if depth == hashDepth {
+			return hashWord(h, uint64(KindObject), 0)
+		}
+		fields := v.ref.(map[string]Value)
+		var sum uint64
+		for key, value := range fields {
+			sum += value.hash(hashString(hashOffset, key), depth+1)
+		}
+		return hashWord(h, uint64(KindObject), sum)
+	case KindFunction:
+		return hashString(hashWord(h, uint64(KindFunction), 0), v.ref.(*Function).Name)
+	case KindError:
+		return hashString(hashWord(h, uint64(KindError), 0), v.ref.(*RuntimeError).Message)
+	}
+	return hashWord(h, uint64(v.kind), v.num)
+}
+
+// hashWord folds a kind and an eight-byte word into h, FNV-1a style.
+func hashWord(h, kind, word uint64) uint64 {
+	h = (h ^ kind) * hashPrime
+	for n := 0; n < 8; n++ {
+		h = (h ^ word&0xff) * hashPrime
+		word >>= 8
+	}
+	return h
+}
+
+func hashString(h uint64, s string) uint64 {
+	for idx := 0; idx < len(s); idx++ {
+		h = (h ^ uint64(s[idx])) * hashPrime
+	}
+	return h
+}
+
+// String prints v the way `state` does.
+func (v Value) String() string {
+	return prettyValue(v)
+}
+
+// TypeName names the kind of v for error messages.
+func (v Value) TypeName() string {
+	return v.kind.String()
+}
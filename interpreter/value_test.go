@@ -0,0 +1,55 @@
+package interpreter
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEqualValuesHashAlike(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Value
+	}{
+		{"int and whole float", IntValue(3), FloatValue(3)},
+		{"zero and negative zero", IntValue(0), FloatValue(math.Copysign(0, -1))},
+		{"int past float precision", IntValue(1<<53 + 1), FloatValue(1 << 53)},
+		{"smallest int", IntValue(math.MinInt64), FloatValue(-1 << 63)},
+		{"largest int", IntValue(math.MaxInt64), FloatValue(1 << 63)},
+		{"arrays of mixed numbers", ArrayValue([]Value{IntValue(1), FloatValue(2)}), ArrayValue([]Value{FloatValue(1), IntValue(2)})},
+		{"objects", ObjectValue(map[string]Value{"a": IntValue(1)}), ObjectValue(map[string]Value{"a": FloatValue(1)})},
+		{"strings", StringValue("rain"), StringValue("rain")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.a.Equal(tt.b) {
+				t.Fatalf("%v and %v are not equal", tt.a, tt.b)
+			}
+			if tt.a.Hash() != tt.b.Hash() {
+				t.Errorf("%v and %v are equal but hash differently", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestCyclicValues(t *testing.T) {
+	a := ArrayValue([]Value{IntValue(1), Nil})
+	(*a.ref.(*[]Value))[1] = a
+	b := ArrayValue([]Value{IntValue(1), Nil})
+	inner := ArrayValue([]Value{IntValue(1), b})
+	(*b.ref.(*[]Value))[1] = inner
+	c := ArrayValue([]Value{IntValue(2), Nil})
+	(*c.ref.(*[]Value))[1] = c
+
+	if !a.Equal(a) || !a.Equal(b) {
+		t.Errorf("arrays holding themselves the same way are not equal")
+	}
+	if a.Equal(c) {
+		t.Errorf("%v and %v are equal", a, c)
+	}
+	if a.Hash() != b.Hash() {
+		t.Errorf("%v and %v are equal but hash differently", a, b)
+	}
+	if got := a.String(); got != "[1, [...]]" {
+		t.Errorf("a prints as %s, want [1, [...]]", got)
+	}
+}
@@ -0,0 +1,623 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+)
+
+// VM runs bytecode from Compile. It produces the same output and errors as
+// the interpreter, whose environments, error reporting and call stack it
+// shares, but keeps values on an operand stack instead of walking the
+// tree.
+type VM struct {
+	interp  *Interpreter
+	globals *Environment
+
+	stack    []Value
+	frames   []frame
+	handlers []handler
+
+	// MaxDepth is the most calls that can be in progress at once, as for
+	// the interpreter.
+	MaxDepth int
+}
+
+func NewVM() *VM {
+	return &VM{interp: New(), MaxDepth: DefaultMaxDepth}
+}
+
+// frame is a running function call, or the top-level statement at the
+// bottom of the frames. base is where its values start on the stack.
+type frame struct {
+	proto *proto
+	fn    *Function
+	call  ast.Node
+	ip    int
+	base  int
+	env   *Environment
+	// returned holds a return value while finally blocks run.
+	returned Value
+}
+
+func (f *frame) u8() int {
+	operand := int(f.proto.code[f.ip])
+	f.ip++
+	return operand
+}
+
+func (f *frame) u16() int {
+	operand := int(f.proto.code[f.ip])<<8 | int(f.proto.code[f.ip+1])
+	f.ip += 2
+	return operand
+}
+
+// handler is an active try block: an error raised while it is installed
+// resumes frame at ip, with the stack cut back to sp.
+type handler struct {
+	frame int
+	ip    int
+	sp    int
+	env   *Environment
+}
+
+// iterator steps through the values of a for or loop statement.
+type iterator struct {
+	array  []Value
+	object map[string]Value
+	keys   []string
+	runes  []rune
+	// keyed is set when an object loop binds both the key and the value.
+	keyed bool
+	// start and end bound a range or count; next is the position of the
+	// next value.
+	start, end int
+	next       int
+	kind       iteratorKind
+}
+
+type iteratorKind int
+
+const (
+	iterateCount iteratorKind = iota
+	iterateArray
+	iterateObject
+	iterateString
+)
+
+// iteratorValue wraps it to sit on the operand stack while its loop runs.
+func iteratorValue(it *iterator) Value {
+	return Value{kind: kindIterator, ref: it}
+}
+
+// step returns the next value and its key, or reports that the iterator is
+// done.
+func (it *iterator) step() (value, key Value, ok bool) {
+	idx := it.next
+	it.next++
+	switch it.kind {
+	case iterateCount:
+		if n := it.start + idx; n < it.end {
+			return IntValue(n), IntValue(idx), true
+		}
+	case iterateArray:
+		if idx < len(it.array) {
+			return it.array[idx], IntValue(idx), true
+		}
+	case iterateObject:
+		if idx < len(it.keys) {
+			key := StringValue(it.keys[idx])
+			if !it.keyed {
+				return key, key, true
+			}
+			return it.object[it.keys[idx]], key, true
+		}
+	case iterateString:
+		if idx < len(it.runes) {
+			return StringValue(string(it.runes[idx])), IntValue(idx), true
+		}
+	}
+	return Nil, Nil, false
+}
+
+// skip moves past the next value without producing it, or reports that the
+// iterator is done.
+func (it *iterator) skip() bool {
+	idx := it.next
+	it.next++
+	switch it.kind {
+	case iterateCount:
+		return it.start+idx < it.end
+	case iterateArray:
+		return idx < len(it.array)
+	case iterateObject:
+		return idx < len(it.keys)
+	case iterateString:
+		return idx < len(it.runes)
+	}
+	return false
+}
+
+// Run executes code one top-level statement at a time, like main does with
+// the interpreter, and returns the runtime error that stopped it, if any.
+func (vm *VM) Run(code *Bytecode) error {
+	vm.interp.MaxDepth = vm.MaxDepth
+	vm.globals = NewEnvironment(code.globals, nil)
+	for _, stmt := range code.statements {
+		if err := vm.execute(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute runs a top-level statement, resuming at the innermost handler
+// whenever an error is raised.
+func (vm *VM) execute(p *proto) *RuntimeError {
+	vm.frames = append(vm.frames[:0], frame{proto: p, env: vm.globals})
+	vm.stack = vm.stack[:0]
+	vm.handlers = vm.handlers[:0]
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+		if !vm.recover(err) {
+			vm.interp.stack = vm.interp.stack[:0]
+			return err
+		}
+	}
+}
+
+// recover unwinds to the innermost handler with err on the stack, or
+// reports that nothing handles it.
+func (vm *VM) recover(err *RuntimeError) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.frames = vm.frames[:h.frame+1]
+	vm.interp.stack = vm.interp.stack[:h.frame]
+	vm.stack = append(vm.stack[:h.sp], ErrorValue(err))
+	f := &vm.frames[h.frame]
+	f.ip = h.ip
+	f.env = h.env
+	return true
+}
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// leave pops the innermost frame, dropping its values and handlers.
+func (vm *VM) leave() *frame {
+	f := &vm.frames[len(vm.frames)-1]
+	for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frame >= len(vm.frames)-1 {
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	}
+	vm.stack = vm.stack[:f.base]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	if f.fn != nil {
+		vm.interp.stack = vm.interp.stack[:len(vm.interp.stack)-1]
+	}
+	return f
+}
+
+// enter starts f running a call of fn, whose function and arguments are on
+// the stack from base up, in place of the innermost call on the
+// interpreter's stack.
+func (vm *VM) enter(f *frame, call ast.Node, fn *Function, base int) {
+	args := vm.stack[base+1:]
+	callEnv := fn.Env.enter(fn.Scope)
+	for idx, arg := range args {
+		callEnv.Define(idx, arg)
+	}
+	vm.interp.stack[len(vm.interp.stack)-1] = Frame{Function: fn.frameName(), Call: call.Pos(), Args: append([]Value{}, args...)}
+	vm.stack = vm.stack[:base]
+	*f = frame{proto: fn.code, fn: fn, call: call, base: base, env: callEnv}
+}
+
+// run executes instructions until the top-level statement returns or an
+// error is raised.
+func (vm *VM) run() *RuntimeError {
+	i := vm.interp
+	f := &vm.frames[len(vm.frames)-1]
+	for {
+		op := opcode(f.proto.code[f.ip])
+		f.ip++
+		switch op {
+		case opConstant:
+			vm.push(f.proto.constants[f.u16()])
+
+		case opNil:
+			vm.push(Nil)
+
+		case opPop:
+			vm.pop()
+
+		case opDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+
+		case opGet:
+			r := &f.proto.refs[f.u16()]
+			value, ok := f.env.Get(r.binding)
+			if !ok {
+				return withSuggestion(i.errorf(NameError, r.node, "variable `%s` is not defined", r.name), r.name, f.env.Names())
+			}
+			vm.push(value)
+
+		case opGetKey:
+			r := &f.proto.refs[f.u16()]
+			switch vm.stack[len(vm.stack)-1].Kind() {
+			case KindObject, KindError:
+				vm.push(StringValue(r.name))
+				continue
+			case KindArray:
+				if r.binding == nil {
+					return i.bareKeyError(r.node, r.name)
+				}
+			}
+			value, ok := f.env.Get(r.binding)
+			if !ok {
+				return withSuggestion(i.errorf(NameError, r.node, "variable `%s` is not defined", r.name), r.name, f.env.Names())
+			}
+			vm.push(value)
+
+		case opSet:
+			f.env.Set(f.proto.refs[f.u16()].binding, vm.pop())
+
+		case opDefine:
+			f.env.Define(f.u16(), vm.pop())
+
+		case opEnterScope:
+			f.env = NewEnvironment(f.proto.scopes[f.u16()], f.env)
+
+		case opLeaveScope:
+			for n := f.u8(); n > 0; n-- {
+				f.env = f.env.parent
+			}
+
+		case opBinary:
+			operator, node := f.u8(), f.u16()
+			right := vm.pop()
+			left := vm.pop()
+			if result, ok := intFastPath(operator, left, right); ok {
+				vm.push(result)
+				continue
+			}
+			result, err := i.evalBinary(f.proto.nodes[node], operators[operator], left, right)
+			if err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case opPrefix:
+			operator, node := f.u8(), f.u16()
+			result, err := i.evalPrefix(f.proto.nodes[node], operators[operator], vm.pop())
+			if err != nil {
+				return err
+			}
+			vm.push(result)
+
+		case opAnd, opOr:
+			target := f.u16()
+			if truthy := vm.pop().Truthy(); truthy == (op == opOr) {
+				vm.push(BoolValue(truthy))
+				f.ip = target
+			}
+
+		case opTruthy:
+			vm.stack[len(vm.stack)-1] = BoolValue(vm.stack[len(vm.stack)-1].Truthy())
+
+		case opCaseEqual:
+			candidate := vm.pop()
+			vm.push(BoolValue(vm.pop().Equal(candidate)))
+
+		case opJump:
+			f.ip = f.u16()
+
+		case opJumpIfFalse:
+			target := f.u16()
+			if !vm.pop().Truthy() {
+				f.ip = target
+			}
+
+		case opFunction:
+			p := f.proto.protos[f.u16()]
+			vm.push(FunctionValue(&Function{Name: p.fn.Name, Parameters: p.fn.Parameters, Scope: p.fn.Scope, Env: f.env, code: p}))
+
+		case opLookupFunction:
+			r, node := &f.proto.refs[f.u16()], f.proto.nodes[f.u16()]
+			value, ok := f.env.Get(r.binding)
+			if !ok {
+				return withSuggestion(i.errorf(NameError, node, "function `%s` is not defined", r.name), r.name, f.env.Names())
+			}
+			if value.Kind() != KindFunction {
+				err := i.errorf(TypeError, node, "`%s` is not a function", r.name)
+				err.Hint = fmt.Sprintf("`%s` holds %s", r.name, prettyValue(value))
+				return err
+			}
+			vm.push(value)
+
+		case opCheckFunction:
+			node := f.proto.nodes[f.u16()]
+			if callee := vm.stack[len(vm.stack)-1]; callee.Kind() != KindFunction {
+				return i.errorf(TypeError, node, "%s is not a function", prettyValue(callee))
+			}
+
+		case opCall:
+			argc, call := f.u8(), f.proto.nodes[f.u16()]
+			base := len(vm.stack) - argc - 1
+			fn, _ := vm.stack[base].AsFunction()
+			if argc != len(fn.Parameters) {
+				return i.arityError(call, fn, argc)
+			}
+			if len(i.stack) >= i.MaxDepth {
+				return i.recursionError(call, fn)
+			}
+
+			i.stack = append(i.stack, Frame{})
+			vm.frames = append(vm.frames, frame{})
+			f = &vm.frames[len(vm.frames)-1]
+			vm.enter(f, call, fn, base)
+
+		case opTailCall:
+			argc, call := f.u8(), f.proto.nodes[f.u16()]
+			base := len(vm.stack) - argc - 1
+			fn, _ := vm.stack[base].AsFunction()
+			if argc != len(fn.Parameters) {
+				return i.arityError(call, fn, argc)
+			}
+
+			// The call's arguments move down to where the current frame's
+			// values start, and the call takes over its frame.
+			n := copy(vm.stack[f.base:], vm.stack[base:])
+			vm.stack = vm.stack[:f.base+n]
+			vm.enter(f, call, fn, f.base)
+
+		case opState:
+			argc := f.u8()
+			values := vm.stack[len(vm.stack)-argc:]
+			parts := make([]string, argc)
+			for idx, value := range values {
+				parts[idx] = prettyValue(value)
+			}
+			fmt.Printf("%s\n", strings.Join(parts, " "))
+			first := values[0]
+			vm.stack = vm.stack[:len(vm.stack)-argc]
+			vm.push(first)
+
+		case opAsk:
+			var prompt string
+			if f.u8() == 1 {
+				prompt, _ = vm.pop().AsString()
+			}
+			if prompt != "" {
+				fmt.Print(prompt)
+			}
+			var input string
+			fmt.Scanln(&input)
+			vm.push(StringValue(input))
+
+		case opReturn:
+			value := vm.pop()
+			vm.leave()
+			if len(vm.frames) == 0 {
+				return nil
+			}
+			vm.push(value)
+			f = &vm.frames[len(vm.frames)-1]
+
+		case opSaveReturn:
+			f.returned = vm.pop()
+
+		case opLoadReturn:
+			vm.push(f.returned)
+
+		case opEscape:
+			kind, node := flowKind(f.u8()), f.proto.nodes[f.u16()]
+			if f.fn == nil {
+				return i.errorf(SyntaxError, node, "`%s` used outside of a loop", kind)
+			}
+			left := vm.leave()
+			return i.errorf(SyntaxError, left.call, "`%s` used outside of a loop in %s", kind, left.fn.description())
+
+		case opArray:
+			count := f.u16()
+			var elements []Value
+			for _, value := range vm.stack[len(vm.stack)-count:] {
+				if !value.IsNil() {
+					elements = append(elements, value)
+				}
+			}
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(ArrayValue(elements))
+
+		case opObject:
+			keys := f.proto.lists[f.u16()]
+			values := vm.stack[len(vm.stack)-len(keys):]
+			obj := make(map[string]Value)
+			for idx, key := range keys {
+				obj[key] = values[idx]
+			}
+			vm.stack = vm.stack[:len(vm.stack)-len(keys)]
+			vm.push(ObjectValue(obj))
+
+		case opIndex:
+			node := f.proto.nodes[f.u16()]
+			key := vm.pop()
+			value, err := i.indexValue(node, vm.pop(), key)
+			if err != nil {
+				return err
+			}
+			vm.push(value)
+
+		case opRange:
+			node := f.proto.nodes[f.u16()]
+			end := vm.pop()
+			start, stop, err := i.checkRange(node, vm.pop(), end)
+			if err != nil {
+				return err
+			}
+			elements := []Value{}
+			for n := start; n < stop; n++ {
+				elements = append(elements, IntValue(n))
+			}
+			vm.push(ArrayValue(elements))
+
+		case opInterpolate:
+			count := f.u16()
+			var b strings.Builder
+			for _, value := range vm.stack[len(vm.stack)-count:] {
+				b.WriteString(prettyValue(value))
+			}
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(StringValue(b.String()))
+
+		case opDescend:
+			keyNode, stmt := f.proto.nodes[f.u16()], f.proto.nodes[f.u16()]
+			top := len(vm.stack)
+			element, err := i.descend(stmt, keyNode, vm.stack[top-2], vm.stack[top-1])
+			if err != nil {
+				return err
+			}
+			vm.push(element)
+
+		case opAssignPath:
+			depth, keyNode, stmt := f.u8(), f.proto.nodes[f.u16()], f.proto.nodes[f.u16()]
+			base := len(vm.stack) - 2*depth
+			path := vm.stack[base:]
+			result, err := i.assignLeaf(stmt, keyNode, path[2*depth-2], path[2*depth-1], vm.stack[base-1])
+			if err != nil {
+				return err
+			}
+			for level := depth - 2; level >= 0; level-- {
+				result = store(path[2*level], path[2*level+1], result)
+			}
+			vm.stack = vm.stack[:base-1]
+			vm.push(result)
+
+		case opTry:
+			vm.handlers = append(vm.handlers, handler{frame: len(vm.frames) - 1, ip: f.u16(), sp: len(vm.stack), env: f.env})
+
+		case opEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case opThrow:
+			node := f.proto.nodes[f.u16()]
+			return i.raise(node, vm.pop())
+
+		case opMatch:
+			kinds, target := f.proto.lists[f.u16()], f.u16()
+			if err, _ := vm.stack[len(vm.stack)-1].AsError(); !handles(kinds, err) {
+				f.ip = target
+			}
+
+		case opIterate:
+			keyed, node := f.u8() == 1, f.proto.nodes[f.u16()]
+			it := &iterator{keyed: keyed}
+			iterable := vm.pop()
+			switch iterable.Kind() {
+			case KindArray:
+				it.kind = iterateArray
+				it.array, _ = iterable.AsArray()
+			case KindObject:
+				it.kind = iterateObject
+				it.object, _ = iterable.AsObject()
+				for key := range it.object {
+					it.keys = append(it.keys, key)
+				}
+				sort.Strings(it.keys)
+			case KindString:
+				text, _ := iterable.AsString()
+				it.kind, it.runes = iterateString, []rune(text)
+			default:
+				return i.errorf(TypeError, node, "cannot iterate over %s", iterable.TypeName())
+			}
+			vm.push(iteratorValue(it))
+
+		case opIterateRange:
+			node := f.proto.nodes[f.u16()]
+			end := vm.pop()
+			start, stop, err := i.checkRange(node, vm.pop(), end)
+			if err != nil {
+				return err
+			}
+			vm.push(iteratorValue(&iterator{kind: iterateCount, start: start, end: stop}))
+
+		case opIterateCount:
+			node := f.proto.nodes[f.u16()]
+			value := vm.pop()
+			count, ok := value.AsInt()
+			if !ok {
+				return i.loopCountError(node, value)
+			}
+			vm.push(iteratorValue(&iterator{kind: iterateCount, end: count}))
+
+		case opNext:
+			values, target := f.u8(), f.u16()
+			it := vm.stack[len(vm.stack)-1].ref.(*iterator)
+			if values == 0 {
+				if !it.skip() {
+					f.ip = target
+				}
+				continue
+			}
+			value, key, ok := it.step()
+			if !ok {
+				f.ip = target
+				continue
+			}
+			vm.push(value)
+			if values == 2 {
+				vm.push(key)
+			}
+
+		default:
+			panic(fmt.Sprintf("unknown opcode: %s", op))
+		}
+	}
+}
+
+// intFastPath applies the arithmetic and comparison operators that cannot
+// fail to two ints without going through evalBinary.
+func intFastPath(operator int, left, right Value) (Value, bool) {
+	a, ok := left.AsInt()
+	if !ok {
+		return Nil, false
+	}
+	b, ok := right.AsInt()
+	if !ok {
+		return Nil, false
+	}
+	switch operator {
+	case operatorAdd:
+		return IntValue(a + b), true
+	case operatorSubtract:
+		return IntValue(a - b), true
+	case operatorMultiply:
+		return IntValue(a * b), true
+	case operatorEqual:
+		return BoolValue(a == b), true
+	case operatorNotEqual:
+		return BoolValue(a != b), true
+	case operatorLess:
+		return BoolValue(a < b), true
+	case operatorGreater:
+		return BoolValue(a > b), true
+	case operatorLessEqual:
+		return BoolValue(a <= b), true
+	case operatorGreaterEqual:
+		return BoolValue(a >= b), true
+	}
+	return Nil, false
+}
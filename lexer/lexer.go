@@ -0,0 +1,536 @@
+// Package lexer turns raingoer source into a stream of typed tokens. It is
+// the single definition of the language's lexical rules; both parsers
+// consume its output.
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenType classifies a token.
+type TokenType int
+
+const (
+	ILLEGAL TokenType = iota
+	EOF
+	NEWLINE
+	COMMENT
+	IDENT
+	NUMBER
+	STRING
+	KEYWORD
+	OPERATOR
+	PUNCT
+)
+
+var tokenTypeNames = map[TokenType]string{
+	ILLEGAL:  "ILLEGAL",
+	EOF:      "EOF",
+	NEWLINE:  "NEWLINE",
+	COMMENT:  "COMMENT",
+	IDENT:    "IDENT",
+	NUMBER:   "NUMBER",
+	STRING:   "STRING",
+	KEYWORD:  "KEYWORD",
+	OPERATOR: "OPERATOR",
+	PUNCT:    "PUNCT",
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// Token is a single lexical element. Line and Column are 1-based and point
+// at the token's first character; EndLine and EndColumn point just past its
+// last one. Columns count runes. For strings, Literal holds the value the
+// string stands for, with escape sequences decoded; for a string with
+// interpolations it holds the text between the quotes as written, and
+// Parts holds the pieces.
+type Token struct {
+	Type      TokenType
+	Literal   string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	// SpaceBefore reports whether whitespace or the start of a line comes
+	// directly before the token. It separates arr{0} (access) from
+	// f x {0} (an array argument), and a - 1 from the argument pair a -1.
+	SpaceBefore bool
+	// Err explains why an ILLEGAL token could not be read.
+	Err string
+	// Parts splits a string containing ${...} into its pieces, in order.
+	// It is nil for a string without interpolations.
+	Parts []StringPart
+}
+
+// StringPart is one piece of an interpolated string: literal Text or, when
+// Tokens is not nil, the tokens of one ${...} expression.
+type StringPart struct {
+	Text   string
+	Tokens []Token
+}
+
+// Is reports whether the token is the given keyword, operator or
+// punctuation. String literals never match, so "end" in quotes is not the
+// end keyword.
+func (t Token) Is(literal string) bool {
+	return t.Type != STRING && t.Literal == literal
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%s(%q) at %d:%d", t.Type, t.Literal, t.Line, t.Column)
+}
+
+var keywords = map[string]bool{
+	"func": true, "fn": true, "end": true, "set": true, "to": true,
+	"loop": true, "as": true, "while": true, "for": true, "in": true,
+	"if": true, "else": true, "elif": true, "switch": true, "case": true,
+	"default": true, "try": true, "catch": true, "finally": true, "throw": true, "return": true,
+	"break": true, "continue": true, "and": true, "or": true, "not": true,
+	"true": true, "false": true,
+}
+
+// IsKeyword reports whether word is reserved.
+func IsKeyword(word string) bool {
+	return keywords[word]
+}
+
+// Operators, longest first so "==" wins over "=".
+var operators = []string{
+	"++", "==", "!=", "<=", ">=", "&&", "||", "..", "->",
+	"+", "-", "*", "/", "%", "<", ">", "!",
+}
+
+const punctuation = "()[]{},:"
+
+// Lexer produces tokens from source text one at a time.
+type Lexer struct {
+	input  []rune
+	pos    int
+	line   int
+	column int
+	// depth counts open brackets, braces and parentheses. Newlines inside
+	// them are not emitted, so a literal or call may span several lines.
+	depth int
+	// brackets holds the brackets, braces and parentheses still open,
+	// outermost first.
+	brackets []Token
+}
+
+func New(input string) *Lexer {
+	return &Lexer{
+		input:  []rune(input),
+		line:   1,
+		column: 1,
+	}
+}
+
+// Tokenize returns every token in input, ending with an EOF token.
+func Tokenize(input string) []Token {
+	l := New(input)
+	var tokens []Token
+	for {
+		tok := l.NextToken()
+		if tok.Type == EOF && len(l.brackets) > 0 {
+			tokens = l.reopen(tokens)
+			continue
+		}
+		tokens = append(tokens, tok)
+		if tok.Type == EOF {
+			return tokens
+		}
+	}
+}
+
+// reopen recovers from a bracket that is still open at the end of the
+// input, which has kept every newline after it from being emitted. The
+// line the bracket opened on ends there, and the tokens after it are
+// dropped so that the rest of the input is lexed again as separate lines.
+// The parser reports the bracket itself as never closed.
+func (l *Lexer) reopen(tokens []Token) []Token {
+	opener := l.brackets[0]
+	last, kept := opener.Line, 0
+	for kept < len(tokens) && tokens[kept].Line <= opener.Line {
+		last = max(last, tokens[kept].EndLine)
+		kept++
+	}
+	tokens = append(tokens[:kept], Token{Type: NEWLINE, Literal: "\n", Line: last, EndLine: last})
+
+	l.pos, l.line, l.column = 0, 1, 1
+	for l.pos < len(l.input) && l.line <= last {
+		l.advance()
+	}
+	l.depth, l.brackets = 0, nil
+	return tokens
+}
+
+func (l *Lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+func (l *Lexer) advance() rune {
+	ch := l.input[l.pos]
+	l.pos++
+	if ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	return ch
+}
+
+// NextToken returns the next token, or EOF once the input is exhausted.
+func (l *Lexer) NextToken() Token {
+	tok := l.scan()
+	if tok.EndLine == 0 {
+		tok.EndLine, tok.EndColumn = l.line, l.column
+	}
+	return tok
+}
+
+func (l *Lexer) scan() Token {
+	spaceBefore := l.column == 1
+	for l.pos < len(l.input) {
+		ch := l.peek(0)
+		if ch == '\n' && l.depth == 0 {
+			break
+		}
+		if !unicode.IsSpace(ch) {
+			break
+		}
+		l.advance()
+		spaceBefore = true
+	}
+
+	tok := Token{Line: l.line, Column: l.column, SpaceBefore: spaceBefore}
+	if l.pos >= len(l.input) {
+		tok.Type = EOF
+		return tok
+	}
+
+	ch := l.peek(0)
+	switch {
+	case ch == '\n':
+		l.advance()
+		tok.Type, tok.Literal = NEWLINE, "\n"
+
+	case ch == '/' && l.peek(1) == '/':
+		start := l.pos
+		for l.pos < len(l.input) && l.peek(0) != '\n' {
+			l.advance()
+		}
+		tok.Type, tok.Literal = COMMENT, string(l.input[start:l.pos])
+
+	case l.hasPrefix(`"""`):
+		l.readString(&tok, `"""`)
+
+	case ch == '"':
+		l.readString(&tok, `"`)
+
+	case ch == '`':
+		l.readRawString(&tok)
+
+	case isDigit(ch):
+		tok.Type, tok.Literal = NUMBER, l.readNumber()
+
+	case isLetter(ch):
+		start := l.pos
+		for l.pos < len(l.input) && (isLetter(l.peek(0)) || isDigit(l.peek(0))) {
+			l.advance()
+		}
+		tok.Literal = string(l.input[start:l.pos])
+		tok.Type = IDENT
+		if keywords[tok.Literal] {
+			tok.Type = KEYWORD
+		}
+
+	case strings.ContainsRune(punctuation, ch):
+		l.advance()
+		tok.Type, tok.Literal = PUNCT, string(ch)
+		switch ch {
+		case '(', '[', '{':
+			l.depth++
+			l.brackets = append(l.brackets, tok)
+		case ')', ']', '}':
+			if l.depth > 0 {
+				l.depth--
+			}
+			if len(l.brackets) > 0 {
+				l.brackets = l.brackets[:len(l.brackets)-1]
+			}
+		}
+
+	default:
+		for _, op := range operators {
+			if l.hasPrefix(op) {
+				for range op {
+					l.advance()
+				}
+				tok.Type, tok.Literal = OPERATOR, op
+				return tok
+			}
+		}
+		l.advance()
+		tok.Type, tok.Literal = ILLEGAL, string(ch)
+		tok.Err = fmt.Sprintf("unexpected character %q", ch)
+	}
+
+	return tok
+}
+
+func (l *Lexer) hasPrefix(s string) bool {
+	for idx, ch := range []rune(s) {
+		if l.peek(idx) != ch {
+			return false
+		}
+	}
+	return true
+}
+
+// readString consumes a string delimited by quote, either `"` or `"""`,
+// and decodes its escape sequences into tok.Literal. Only a triple-quoted
+// string may span lines; a newline straight after its opening quotes is
+// dropped so the text can start on the next line. Each ${...} in the
+// string is lexed as an expression and recorded in tok.Parts.
+//
+// An unterminated string makes tok ILLEGAL, and so does an invalid escape
+// or interpolation, in which case tok is narrowed to the first bad one.
+func (l *Lexer) readString(tok *Token, quote string) {
+	start := l.pos
+	l.skip(len(quote))
+	if quote == `"""` && l.peek(0) == '\n' {
+		l.advance()
+	}
+	contentStart := l.pos
+
+	var value strings.Builder
+	var parts []StringPart
+	var invalid *Token
+	for l.pos < len(l.input) {
+		if l.hasPrefix(quote) {
+			literal := string(l.input[contentStart:l.pos])
+			l.skip(len(quote))
+			switch {
+			case invalid != nil:
+				*tok = *invalid
+			case parts != nil:
+				if value.Len() > 0 {
+					parts = append(parts, StringPart{Text: value.String()})
+				}
+				tok.Type, tok.Literal, tok.Parts = STRING, literal, parts
+			default:
+				tok.Type, tok.Literal = STRING, value.String()
+			}
+			return
+		}
+		ch := l.peek(0)
+		if ch == '\n' && quote == `"` {
+			break
+		}
+		if ch == '$' && l.peek(1) == '{' {
+			if value.Len() > 0 {
+				parts = append(parts, StringPart{Text: value.String()})
+				value.Reset()
+			}
+			tokens, bad := l.readInterpolation()
+			if bad != nil && l.pos >= len(l.input) {
+				*tok = *bad
+				return
+			}
+			if bad != nil && invalid == nil {
+				invalid = bad
+			}
+			parts = append(parts, StringPart{Tokens: tokens})
+			continue
+		}
+		if ch != '\\' {
+			value.WriteRune(l.advance())
+			continue
+		}
+
+		escape := Token{Type: ILLEGAL, Line: l.line, Column: l.column}
+		escapeStart := l.pos
+		r, ok := l.readEscape()
+		if !ok && invalid == nil {
+			escape.Literal = string(l.input[escapeStart:l.pos])
+			escape.EndLine, escape.EndColumn = l.line, l.column
+			escape.Err = fmt.Sprintf("invalid escape sequence `%s`", escape.Literal)
+			invalid = &escape
+		}
+		value.WriteRune(r)
+	}
+	tok.Type, tok.Literal = ILLEGAL, string(l.input[start:l.pos])
+	tok.Err = "unterminated string"
+}
+
+// readInterpolation consumes ${...} inside a string and returns the tokens
+// of the expression between the braces, which may itself contain braces
+// and strings. It returns an ILLEGAL token instead when the expression is
+// empty, holds an ILLEGAL token, or is never closed.
+func (l *Lexer) readInterpolation() ([]Token, *Token) {
+	open := Token{Type: ILLEGAL, Literal: "${", Line: l.line, Column: l.column}
+	l.skip(2)
+	// Counting the brace as open lets the expression span lines; the
+	// closing brace closes it again.
+	l.depth++
+
+	var tokens []Token
+	var invalid *Token
+	nested := 0
+	for {
+		tok := l.NextToken()
+		switch {
+		case tok.Type == EOF:
+			l.depth--
+			open.EndLine, open.EndColumn = open.Line, open.Column+2
+			open.Err = "`${` is never closed with `}`"
+			return nil, &open
+		case tok.Type == ILLEGAL:
+			if invalid == nil {
+				invalid = &tok
+			}
+			continue
+		case tok.Is("{"):
+			nested++
+		case tok.Is("}"):
+			if nested > 0 {
+				nested--
+				break
+			}
+			if invalid != nil {
+				return nil, invalid
+			}
+			if len(tokens) == 0 {
+				open.Literal = "${}"
+				open.EndLine, open.EndColumn = l.line, l.column
+				open.Err = "expected an expression inside `${}`"
+				return nil, &open
+			}
+			return tokens, nil
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// readEscape consumes an escape sequence starting at a backslash and
+// returns the character it stands for. It reports false for anything other
+// than \n, \t, \r, \\, \", \$ and \u{...} with one to six hex digits.
+func (l *Lexer) readEscape() (rune, bool) {
+	l.advance()
+	if l.pos >= len(l.input) || l.peek(0) == '\n' {
+		return 0, false
+	}
+
+	switch l.advance() {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '\\':
+		return '\\', true
+	case '"':
+		return '"', true
+	case '$':
+		return '$', true
+	case 'u':
+		if l.peek(0) != '{' {
+			return 0, false
+		}
+		l.advance()
+		start := l.pos
+		for isHexDigit(l.peek(0)) {
+			l.advance()
+		}
+		digits := string(l.input[start:l.pos])
+		if l.peek(0) != '}' {
+			return 0, false
+		}
+		l.advance()
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+			return 0, false
+		}
+		return rune(code), true
+	}
+	return 0, false
+}
+
+// readRawString consumes a backtick string. Nothing inside it is an escape
+// and it may span lines.
+func (l *Lexer) readRawString(tok *Token) {
+	start := l.pos
+	l.advance()
+	for l.pos < len(l.input) {
+		if l.peek(0) == '`' {
+			tok.Type, tok.Literal = STRING, string(l.input[start+1:l.pos])
+			l.advance()
+			return
+		}
+		l.advance()
+	}
+	tok.Type, tok.Literal = ILLEGAL, string(l.input[start:l.pos])
+	tok.Err = "unterminated raw string"
+}
+
+// skip advances past n characters.
+func (l *Lexer) skip(n int) {
+	for range n {
+		l.advance()
+	}
+}
+
+// readNumber consumes an integer or float such as 42, 3.14 or 1e-3. A dot
+// only continues the number when a digit follows, so 0..10 is a range.
+func (l *Lexer) readNumber() string {
+	start := l.pos
+	for isDigit(l.peek(0)) {
+		l.advance()
+	}
+	if l.peek(0) == '.' && isDigit(l.peek(1)) {
+		l.advance()
+		for isDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	if l.peek(0) == 'e' || l.peek(0) == 'E' {
+		next := 1
+		if l.peek(1) == '+' || l.peek(1) == '-' {
+			next = 2
+		}
+		if isDigit(l.peek(next)) {
+			for range next {
+				l.advance()
+			}
+			for isDigit(l.peek(0)) {
+				l.advance()
+			}
+		}
+	}
+	return string(l.input[start:l.pos])
+}
+
+func isDigit(ch rune) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+func isLetter(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch)
+}
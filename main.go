@@ -3,42 +3,143 @@ package main
 import (
 	"fmt"
 	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 	"github.com/mistium/raingoer/parser"
 	"github.com/mistium/raingoer/interpreter"
 	"github.com/mistium/raingoer/ast"
+	"github.com/mistium/raingoer/diag"
 )
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: raingoer <filename>")
+		fmt.Println("Usage: raingoer <filename> [--optimize] [--max-depth=N] [--ast | --vm | --bytecode]")
 		os.Exit(1)
 	}
 
-	fi, err := os.ReadFile(os.Args[1])
+	filename := os.Args[1]
+	fi, err := os.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "error: cannot read %s: %v\n", filename, err)
+		os.Exit(1)
 	}
 
 	code := string(fi)
 
-	p := parser.NewLineParser(code)
-	program := p.Parse()
+	p := parser.NewLineParser(filename, code)
+	program, errs := p.Parse()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprint(os.Stderr, diag.Render(parseDiagnostic(err), code))
+		}
+		os.Exit(1)
+	}
+
+	if errs := interpreter.Resolve(program); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprint(os.Stderr, diag.Render(runtimeDiagnostic(err), code))
+		}
+		os.Exit(1)
+	}
+
+	flags := make(map[string]bool)
+	maxDepth := interpreter.DefaultMaxDepth
+	for _, arg := range os.Args[2:] {
+		if value, ok := strings.CutPrefix(arg, "--max-depth="); ok {
+			if maxDepth, err = strconv.Atoi(value); err != nil || maxDepth < 1 || maxDepth > interpreter.MaxDepthLimit {
+				fmt.Fprintf(os.Stderr, "error: --max-depth must be a number from 1 to %d, not %q\n", interpreter.MaxDepthLimit, value)
+				os.Exit(1)
+			}
+			continue
+		}
+		flags[arg] = true
+	}
+
+	if flags["--optimize"] {
+		interpreter.Optimize(program)
+	}
 
-	if len(os.Args) > 2 && os.Args[2] == "--ast" {
+	if flags["--ast"] {
 		fmt.Println("AST:")
 		fmt.Println(program.String())
 		return
 	}
 
+	if flags["--vm"] || flags["--bytecode"] {
+		runVM(program, code, maxDepth, flags["--bytecode"])
+		return
+	}
+
 	interp := interpreter.New()
+	interp.MaxDepth = maxDepth
+	// Go's own stack limit is 1GB by default; recursion close to maxDepth
+	// may need more.
+	if stack := maxDepth * interpreter.StackPerCall; stack > 1<<30 {
+		debug.SetMaxStack(stack)
+	}
 	
 	start := time.Now()
 
 	for _, stmt := range program.Statements {
-		interp.Interpret(&ast.Program{Statements: []ast.Statement{stmt}})
+		if err := interp.Run(&ast.Program{Statements: []ast.Statement{stmt}, Scope: program.Scope}); err != nil {
+			fmt.Fprint(os.Stderr, diag.Render(runtimeDiagnostic(err), code))
+			os.Exit(1)
+		}
 	}
 	
 	duration := time.Since(start)
 	fmt.Printf("Execution time: %v\n", duration)
 }
+
+// runVM compiles program and runs it on the bytecode VM, or only prints
+// the disassembled bytecode when disassemble is set.
+func runVM(program *ast.Program, code string, maxDepth int, disassemble bool) {
+	bytecode, err := interpreter.Compile(program)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if disassemble {
+		fmt.Print(bytecode)
+		return
+	}
+
+	vm := interpreter.NewVM()
+	vm.MaxDepth = maxDepth
+
+	start := time.Now()
+
+	if err := vm.Run(bytecode); err != nil {
+		fmt.Fprint(os.Stderr, diag.Render(runtimeDiagnostic(err), code))
+		os.Exit(1)
+	}
+
+	duration := time.Since(start)
+	fmt.Printf("Execution time: %v\n", duration)
+}
+
+func parseDiagnostic(err *parser.ParseError) diag.Diagnostic {
+	return diag.Diagnostic{
+		Span: ast.Span{
+			Start: ast.Position{File: err.File, Line: err.Line, Column: err.Column},
+			End:   ast.Position{File: err.File, Line: err.EndLine, Column: err.EndColumn},
+		},
+		Message: err.Message,
+	}
+}
+
+func runtimeDiagnostic(err error) diag.Diagnostic {
+	runtimeErr, ok := err.(*interpreter.RuntimeError)
+	if !ok {
+		return diag.Diagnostic{Message: err.Error()}
+	}
+	return diag.Diagnostic{
+		Span:      runtimeErr.Span,
+		Kind:      string(runtimeErr.Kind),
+		Message:   runtimeErr.Message,
+		Hint:      runtimeErr.Hint,
+		Traceback: runtimeErr.Traceback(),
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mistium/raingoer/ast"
+	"github.com/mistium/raingoer/diag"
+	"github.com/mistium/raingoer/interpreter"
+	"github.com/mistium/raingoer/parser"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected output of each program in programs/")
+
+// TestPrograms runs every program in programs/ on the interpreter and on the
+// VM, each plain and optimized, and checks that all four print the output
+// and errors kept next to it in a .out file. go test -run TestPrograms
+// -update rewrites those files from the interpreter's output.
+func TestPrograms(t *testing.T) {
+	files, err := filepath.Glob("programs/*.rgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			golden := strings.TrimSuffix(file, ".rgo") + ".out"
+			if *update {
+				if err := os.WriteFile(golden, []byte(runProgram(t, file, false, false)), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mode := range []struct {
+				name         string
+				vm, optimize bool
+			}{
+				{"interpreter", false, false},
+				{"interpreter --optimize", false, true},
+				{"--vm", true, false},
+				{"--vm --optimize", true, true},
+			} {
+				if got := runProgram(t, file, mode.vm, mode.optimize); got != string(want) {
+					t.Errorf("%s printed:\n%s\nwant:\n%s", mode.name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// runProgram runs file the way main does, without the execution time, and
+// returns what it printed, with any error that stopped it.
+func runProgram(t *testing.T, file string, vm, optimize bool) string {
+	t.Helper()
+	source, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := string(source)
+	program, errs := parser.NewLineParser(file, code).Parse()
+	if len(errs) > 0 {
+		t.Fatalf("parse error: %v", errs[0])
+	}
+	if errs := interpreter.Resolve(program); len(errs) > 0 {
+		t.Fatalf("resolve error: %v", errs[0])
+	}
+	if optimize {
+		interpreter.Optimize(program)
+	}
+
+	output, err := captureStdout(t, func() error {
+		if vm {
+			bytecode, err := interpreter.Compile(program)
+			if err != nil {
+				return err
+			}
+			return interpreter.NewVM().Run(bytecode)
+		}
+		interp := interpreter.New()
+		for _, stmt := range program.Statements {
+			if err := interp.Run(&ast.Program{Statements: []ast.Statement{stmt}, Scope: program.Scope}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		output += diag.Render(runtimeDiagnostic(err), code)
+	}
+	return output
+}
+
+// captureStdout runs fn with stdout going to a file and stdin empty, and
+// returns what fn printed and the error it returned.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	in, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	stdout, stdin := os.Stdout, os.Stdin
+	os.Stdout, os.Stdin = out, in
+	runErr := fn()
+	os.Stdout, os.Stdin = stdout, stdin
+
+	printed, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(printed), runErr
+}
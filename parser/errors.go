@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/mistium/raingoer/lexer"
+)
+
+// ParseError describes one malformed piece of source. Line and Column are
+// 1-based and point at Token, the token the parser was looking at;
+// EndLine and EndColumn point just past it.
+type ParseError struct {
+	File      string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Token     string
+	Message   string
+}
+
+func (e *ParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// errorList collects parse errors so parsing can carry on past the first
+// one and report everything in a single pass.
+type errorList struct {
+	file   string
+	errors []*ParseError
+}
+
+func (l *errorList) errorf(tok lexer.Token, format string, args ...interface{}) {
+	l.errors = append(l.errors, &ParseError{
+		File:      l.file,
+		Line:      tok.Line,
+		Column:    tok.Column,
+		EndLine:   tok.EndLine,
+		EndColumn: tok.EndColumn,
+		Token:     tok.Literal,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+// describe names a token for an error message.
+func describe(tok lexer.Token) string {
+	switch tok.Type {
+	case lexer.EOF, lexer.NEWLINE:
+		return "end of line"
+	case lexer.STRING:
+		return fmt.Sprintf("string %q", tok.Literal)
+	}
+	return fmt.Sprintf("`%s`", tok.Literal)
+}
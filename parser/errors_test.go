@@ -0,0 +1,79 @@
+package parser
+
+import "testing"
+
+func TestParseErrors(t *testing.T) {
+	type want struct {
+		line, column int
+		message      string
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  []want
+	}{
+		{
+			name:  "set with nothing after to",
+			input: "set x to\n",
+			want:  []want{{1, 7, "expected a value after `to`"}},
+		},
+		{
+			name:  "func with no name",
+			input: "func\n  state 1\nend\n",
+			want:  []want{{1, 1, "expected a function name after `func`"}},
+		},
+		{
+			name:  "while with no end",
+			input: "state 0\nwhile true\n  state 1\n",
+			want:  []want{{2, 1, "block opened by `while` on line 2 is never closed with `end`"}},
+		},
+		{
+			name:  "integer too large for an int",
+			input: "state 1\nset big to 99999999999999999999\n",
+			want:  []want{{2, 12, "integer 99999999999999999999 is out of range; integers run from -9223372036854775808 to 9223372036854775807"}},
+		},
+		{
+			name:  "tokens after a keyword that ends its line",
+			input: "try oops\n  state 1\ncatch e\n  state 2\nend garbage here\nswitch 1\n  default junk\n    state 3\nend\nloop 2\n  break now\n  continue later\nend\n",
+			want: []want{
+				{1, 5, "unexpected `oops` after `try`"},
+				{5, 5, "unexpected `garbage` after `end`"},
+				{7, 11, "unexpected `junk` after `default`"},
+				{11, 9, "unexpected `now` after `break`"},
+				{12, 12, "unexpected `later` after `continue`"},
+			},
+		},
+		{
+			name:  "lines after an unclosed bracket",
+			input: "state (1 + 2\nset y to\nset z to {1, 2\nstate 5\n",
+			want: []want{
+				{1, 7, "`(` is never closed"},
+				{2, 7, "expected a value after `to`"},
+				{3, 10, "`{` is never closed"},
+			},
+		},
+		{
+			name:  "several errors in one file",
+			input: "set x to\nfunc\n  state 1\nend\nwhile true\n  state 2\n",
+			want: []want{
+				{1, 7, "expected a value after `to`"},
+				{2, 1, "expected a function name after `func`"},
+				{5, 1, "block opened by `while` on line 5 is never closed with `end`"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := NewLineParser("test.rgo", tt.input).Parse()
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %d errors %v, want %d", len(errs), errs, len(tt.want))
+			}
+			for idx, err := range errs {
+				w := tt.want[idx]
+				if err.File != "test.rgo" || err.Line != w.line || err.Column != w.column || err.Message != w.message {
+					t.Errorf("error %d = %s, want test.rgo:%d:%d: %s", idx, err, w.line, w.column, w.message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,607 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mistium/raingoer/ast"
+	"github.com/mistium/raingoer/lexer"
+)
+
+// Binding powers for infix operators, lowest first. Every level is left
+// associative.
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precNot
+	precComparison
+	precRange
+	precConcat
+	precSum
+	precProduct
+	precPrefix
+)
+
+func precedence(operator string) int {
+	switch operator {
+	case "or", "||":
+		return precOr
+	case "and", "&&":
+		return precAnd
+	case "==", "!=", "<", ">", "<=", ">=":
+		return precComparison
+	case "..":
+		return precRange
+	case "++":
+		return precConcat
+	case "+", "-":
+		return precSum
+	case "*", "/", "%":
+		return precProduct
+	}
+	return precLowest
+}
+
+// isBinaryOperator reports whether tok can join two operands.
+func isBinaryOperator(tok lexer.Token) bool {
+	switch tok.Type {
+	case lexer.OPERATOR:
+		return precedence(tok.Literal) > precLowest
+	case lexer.KEYWORD:
+		return tok.Literal == "and" || tok.Literal == "or"
+	}
+	return false
+}
+
+// canonicalOperator maps the symbolic spellings of the logical operators to
+// their keyword form so later stages only handle one of each.
+func canonicalOperator(operator string) string {
+	switch operator {
+	case "&&":
+		return "and"
+	case "||":
+		return "or"
+	case "!":
+		return "not"
+	}
+	return operator
+}
+
+// exprParser is a precedence-climbing parser over the tokens of one line.
+type exprParser struct {
+	tokens []lexer.Token
+	pos    int
+	// block parses the lines after the current one up to "end". It backs
+	// "func x y" function literals, whose body follows on later lines.
+	block func() []ast.Statement
+	errs  *errorList
+	// arguments is set while splitting a space-separated argument list,
+	// where "x -1" is two arguments rather than a subtraction.
+	arguments bool
+}
+
+func newExprParser(tokens []lexer.Token, block func() []ast.Statement, errs *errorList) *exprParser {
+	return &exprParser{tokens: tokens, block: block, errs: errs}
+}
+
+func (ep *exprParser) errorf(tok lexer.Token, format string, args ...interface{}) {
+	if ep.errs != nil {
+		ep.errs.errorf(tok, format, args...)
+	}
+}
+
+// file names the source being parsed, for node positions.
+func (ep *exprParser) file() string {
+	if ep.errs == nil {
+		return ""
+	}
+	return ep.errs.file
+}
+
+// spanFrom covers the tokens from start up to the last one consumed.
+func (ep *exprParser) spanFrom(start int) ast.Span {
+	return tokenSpan(ep.file(), ep.tokens[start], ep.tokens[ep.pos-1])
+}
+
+func (ep *exprParser) errorCount() int {
+	if ep.errs == nil {
+		return 0
+	}
+	return len(ep.errs.errors)
+}
+
+// parseTokens parses tokens as a single expression with the same block
+// parser and error list as ep.
+func (ep *exprParser) parseTokens(tokens []lexer.Token) ast.Expression {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return newExprParser(tokens, ep.block, ep.errs).parseComplete()
+}
+
+// parseComplete parses the whole token list as one expression and reports
+// anything left over after it.
+func (ep *exprParser) parseComplete() ast.Expression {
+	before := ep.errorCount()
+	expr := ep.parseExpression(precLowest)
+	if expr != nil && !ep.atEnd() && ep.errorCount() == before {
+		ep.errorf(ep.current(), "unexpected %s after expression", describe(ep.current()))
+	}
+	return expr
+}
+
+func (ep *exprParser) current() lexer.Token {
+	if ep.pos >= len(ep.tokens) {
+		return lexer.Token{Type: lexer.EOF}
+	}
+	return ep.tokens[ep.pos]
+}
+
+func (ep *exprParser) peek() lexer.Token {
+	if ep.pos+1 >= len(ep.tokens) {
+		return lexer.Token{Type: lexer.EOF}
+	}
+	return ep.tokens[ep.pos+1]
+}
+
+func (ep *exprParser) atEnd() bool {
+	return ep.pos >= len(ep.tokens)
+}
+
+func (ep *exprParser) parseExpression(minPrec int) ast.Expression {
+	left := ep.parsePostfix()
+	if left == nil {
+		return nil
+	}
+
+	for isBinaryOperator(ep.current()) && precedence(ep.current().Literal) > minPrec && !ep.atNegativeArgument() {
+		operatorTok := ep.current()
+		operator := operatorTok.Literal
+		ep.pos++
+		if ep.atEnd() {
+			ep.errorf(operatorTok, "expected an expression after `%s`", operator)
+			return left
+		}
+		right := ep.parseExpression(precedence(operator))
+		if right == nil {
+			return left
+		}
+		if operator == ".." {
+			left = &ast.RangeExpression{Span: nodeSpan(left, right), Start: left, End: right}
+			continue
+		}
+		left = &ast.BinaryExpression{
+			Span:     nodeSpan(left, right),
+			Left:     left,
+			Operator: canonicalOperator(operator),
+			Right:    right,
+		}
+	}
+
+	return left
+}
+
+// atNegativeArgument reports whether the current "-" is written like a sign,
+// with a space before it but none after, as in "add x -1". That minus starts
+// the next argument instead of subtracting.
+func (ep *exprParser) atNegativeArgument() bool {
+	tok := ep.current()
+	next := ep.peek()
+	return ep.arguments && tok.Is("-") && tok.SpaceBefore && next.Type != lexer.EOF && !next.SpaceBefore
+}
+
+// parseArguments splits a space-separated argument list into expressions.
+// Each argument extends as far as operators allow, so "x + 1 y" is two
+// arguments: x + 1 and y.
+func (ep *exprParser) parseArguments(tokens []lexer.Token) []ast.Expression {
+	var args []ast.Expression
+	p := newExprParser(tokens, ep.block, ep.errs)
+	p.arguments = true
+	for !p.atEnd() {
+		start := p.pos
+		arg := p.parseExpression(precLowest)
+		if arg != nil {
+			args = append(args, arg)
+		}
+		if p.pos == start {
+			p.pos++
+		}
+	}
+	return args
+}
+
+// parsePostfix parses an operand followed by any number of obj{key}
+// accesses. The brace must touch what comes before it; after a space it
+// opens a literal instead, which keeps "show label {1, 2}" a call with two
+// arguments.
+func (ep *exprParser) parsePostfix() ast.Expression {
+	start := ep.pos
+	expr := ep.parseOperand()
+
+	for expr != nil && ep.current().Is("{") && !ep.current().SpaceBefore {
+		open := ep.current()
+		end := ep.matching(ep.pos)
+		if end == -1 {
+			return nil
+		}
+		inner := ep.tokens[ep.pos+1 : end]
+		ep.pos = end + 1
+		if len(inner) == 0 {
+			ep.errorf(open, "expected a key inside `{}`")
+			return nil
+		}
+		key := ep.parseTokens(inner)
+		if key == nil {
+			return nil
+		}
+		expr = &ast.AccessExpression{
+			Span:   ep.spanFrom(start),
+			Object: expr,
+			Key:    key,
+		}
+	}
+
+	return expr
+}
+
+func (ep *exprParser) parseOperand() ast.Expression {
+	if ep.atEnd() {
+		return nil
+	}
+
+	start := ep.pos
+	token := ep.current()
+	switch {
+	case token.Is("-") && ep.peek().Type == lexer.NUMBER && !ep.peek().SpaceBefore:
+		ep.pos += 2
+		return ep.parseNumber(ep.tokens[ep.pos-1], "-"+ep.tokens[ep.pos-1].Literal, ep.spanFrom(start))
+
+	case token.Is("not"), token.Is("!"), token.Is("-"):
+		ep.pos++
+		if ep.atEnd() {
+			ep.errorf(token, "expected an expression after `%s`", token.Literal)
+			return nil
+		}
+		prec := precNot
+		if token.Is("-") {
+			prec = precPrefix
+		}
+		right := ep.parseExpression(prec)
+		if right == nil {
+			return nil
+		}
+		return &ast.PrefixExpression{
+			Span:     ep.spanFrom(start),
+			Operator: canonicalOperator(token.Literal),
+			Right:    right,
+		}
+
+	case token.Is("("):
+		end := ep.matching(ep.pos)
+		if end == -1 {
+			return nil
+		}
+		inner := ep.tokens[ep.pos+1 : end]
+		ep.pos = end + 1
+		if len(inner) == 0 {
+			ep.errorf(token, "expected an expression inside `()`")
+			return nil
+		}
+		expr := ep.parseTokens(inner)
+		if expr == nil {
+			return nil
+		}
+		return &ast.BracketExpression{Span: ep.spanFrom(start), Expression: expr}
+
+	case token.Is("["):
+		end := ep.matching(ep.pos)
+		if end == -1 {
+			return nil
+		}
+		if end == ep.pos+1 {
+			ep.pos = end + 1
+			span := ep.spanFrom(start)
+			return &ast.BracketExpression{Span: span, Expression: &ast.IntegerLiteral{Span: span, Value: 0}}
+		}
+		// The callee is usually a function name, but any operand works:
+		// [[make_adder 1] 5] or [handlers{click} event].
+		inner := ep.tokens[ep.pos+1 : end]
+		calleeParser := newExprParser(inner, ep.block, ep.errs)
+		callee := calleeParser.parsePostfix()
+		ep.pos = end + 1
+		if callee == nil {
+			return nil
+		}
+		args := ep.parseArguments(inner[calleeParser.pos:])
+		span := ep.spanFrom(start)
+
+		if ident, ok := callee.(*ast.Identifier); ok {
+			return &ast.BracketExpression{Span: span, Expression: &ast.FunctionCall{
+				Span: span,
+				Name: ident.Name,
+				Args: args,
+			}}
+		}
+		return &ast.BracketExpression{Span: span, Expression: &ast.CallExpression{
+			Span:   span,
+			Callee: callee,
+			Args:   args,
+		}}
+
+	case token.Is("fn"):
+		ep.pos++
+		var params []string
+		for !ep.atEnd() && !ep.current().Is("->") {
+			if ep.current().Type != lexer.IDENT {
+				ep.errorf(ep.current(), "expected a parameter name, found %s", describe(ep.current()))
+			}
+			params = append(params, ep.current().Literal)
+			ep.pos++
+		}
+		if ep.atEnd() {
+			ep.errorf(token, "expected `->` after the parameters of `fn`")
+			return nil
+		}
+		arrow := ep.current()
+		ep.pos++
+		if ep.atEnd() {
+			ep.errorf(arrow, "expected an expression after `->`")
+			return nil
+		}
+		body := ep.parseExpression(precLowest)
+		if body == nil {
+			return nil
+		}
+		return &ast.FunctionLiteral{
+			Span:       ep.spanFrom(start),
+			Parameters: params,
+			Body:       []ast.Statement{&ast.ReturnStatement{Span: nodeSpan(body, body), Value: body}},
+		}
+
+	case token.Is("func"):
+		// A block function literal takes the rest of the line as its
+		// parameters and the following lines up to "end" as its body.
+		var params []string
+		for _, tok := range ep.tokens[ep.pos+1:] {
+			if tok.Type != lexer.IDENT {
+				ep.errorf(tok, "expected a parameter name, found %s", describe(tok))
+			}
+			params = append(params, tok.Literal)
+		}
+		ep.pos = len(ep.tokens)
+		span := ep.spanFrom(start)
+		var body []ast.Statement
+		if ep.block != nil {
+			body = ep.block()
+		}
+		return &ast.FunctionLiteral{
+			Span:       span,
+			Parameters: params,
+			Body:       body,
+		}
+
+	case token.Is("{"):
+		end := ep.matching(ep.pos)
+		if end == -1 {
+			return nil
+		}
+		inner := ep.tokens[ep.pos+1 : end]
+		ep.pos = end + 1
+		return ep.parseArrayOrObject(inner, ep.spanFrom(start))
+
+	case token.Type == lexer.STRING && token.Parts != nil:
+		ep.pos++
+		return ep.parseInterpolation(token)
+
+	case token.Type == lexer.NUMBER:
+		ep.pos++
+		if number := ep.parseNumber(token, token.Literal, tokenSpan(ep.file(), token, token)); number != nil {
+			return number
+		}
+		ep.errorf(token, "unexpected %s in expression", describe(token))
+		return nil
+	}
+
+	primary := parsePrimary(token, tokenSpan(ep.file(), token, token))
+	if primary == nil {
+		ep.errorf(token, "unexpected %s in expression", describe(token))
+		ep.pos++
+		return nil
+	}
+	ep.pos++
+	return primary
+}
+
+// parseInterpolation builds the node for a string containing ${...}. Each
+// embedded expression is parsed on its own, so it may use anything an
+// expression can, including bracket calls and obj{key} access.
+func (ep *exprParser) parseInterpolation(token lexer.Token) ast.Expression {
+	span := tokenSpan(ep.file(), token, token)
+	parts := make([]ast.Expression, 0, len(token.Parts))
+	for _, part := range token.Parts {
+		if part.Tokens == nil {
+			parts = append(parts, &ast.StringLiteral{Span: span, Value: part.Text})
+			continue
+		}
+		expr := ep.parseTokens(part.Tokens)
+		if expr == nil {
+			return nil
+		}
+		parts = append(parts, expr)
+	}
+	return &ast.InterpolatedString{Span: span, Parts: parts}
+}
+
+// matching returns the index of the token closing the group opened at
+// start. An unclosed group is reported and consumes the rest of the tokens,
+// returning -1.
+func (ep *exprParser) matching(start int) int {
+	depth := 0
+	for i := start; i < len(ep.tokens); i++ {
+		if ep.tokens[i].Type != lexer.PUNCT {
+			continue
+		}
+		switch ep.tokens[i].Literal {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	ep.errorf(ep.tokens[start], "`%s` is never closed", ep.tokens[start].Literal)
+	ep.pos = len(ep.tokens)
+	return -1
+}
+
+// splitTopLevel splits tokens at commas that are not nested inside
+// brackets, braces or parentheses.
+func splitTopLevel(tokens []lexer.Token) [][]lexer.Token {
+	var parts [][]lexer.Token
+	var current []lexer.Token
+	depth := 0
+	for _, tok := range tokens {
+		if tok.Type == lexer.PUNCT {
+			switch tok.Literal {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			case ",":
+				if depth == 0 {
+					parts = append(parts, current)
+					current = nil
+					continue
+				}
+			}
+		}
+		current = append(current, tok)
+	}
+	if len(current) > 0 {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// parseArrayOrObject parses the tokens between a pair of braces, which span
+// covers. It is an object if any top-level element has a colon; `{:}` is
+// the empty object and `{}` the empty array.
+func (ep *exprParser) parseArrayOrObject(inner []lexer.Token, span ast.Span) ast.Expression {
+	if len(inner) == 1 && inner[0].Is(":") {
+		return &ast.ObjectLiteral{Span: span, Properties: []ast.ObjectProperty{}}
+	}
+	parts := splitTopLevel(inner)
+	if len(parts) == 0 {
+		return &ast.ArrayLiteral{Span: span, Elements: []ast.Expression{}}
+	}
+
+	for _, part := range parts {
+		if len(part) > 1 && part[1].Is(":") {
+			return ep.parseObject(parts, span)
+		}
+	}
+	return ep.parseArray(parts, span)
+}
+
+func (ep *exprParser) parseArray(parts [][]lexer.Token, span ast.Span) *ast.ArrayLiteral {
+	elements := make([]ast.Expression, 0, len(parts))
+	for _, part := range parts {
+		if expr := ep.parseTokens(part); expr != nil {
+			elements = append(elements, expr)
+		}
+	}
+	return &ast.ArrayLiteral{Span: span, Elements: elements}
+}
+
+func (ep *exprParser) parseObject(parts [][]lexer.Token, span ast.Span) *ast.ObjectLiteral {
+	properties := make([]ast.ObjectProperty, 0, len(parts))
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if prop := ep.parseObjectProperty(part); prop != nil {
+			properties = append(properties, *prop)
+		}
+	}
+	return &ast.ObjectLiteral{Span: span, Properties: properties}
+}
+
+func (ep *exprParser) parseObjectProperty(tokens []lexer.Token) *ast.ObjectProperty {
+	if len(tokens) < 2 || !tokens[1].Is(":") {
+		ep.errorf(tokens[0], "expected `key: value` in object literal")
+		return nil
+	}
+	if len(tokens) == 2 {
+		ep.errorf(tokens[1], "expected a value after `:`")
+		return nil
+	}
+	if tokens[0].Parts != nil {
+		ep.errorf(tokens[0], "object keys cannot contain `${}`")
+		return nil
+	}
+	key := tokens[0].Literal
+	valueTokens := tokens[2:]
+
+	// A lone bare word as a value is taken as a string, as in {name: Alice}.
+	var value ast.Expression
+	if len(valueTokens) == 1 && valueTokens[0].Type == lexer.IDENT {
+		value = &ast.StringLiteral{
+			Span:  tokenSpan(ep.file(), valueTokens[0], valueTokens[0]),
+			Value: valueTokens[0].Literal,
+		}
+	} else {
+		value = ep.parseTokens(valueTokens)
+	}
+	if value == nil {
+		return nil
+	}
+	return &ast.ObjectProperty{
+		Span:  tokenSpan(ep.file(), tokens[0], tokens[len(tokens)-1]),
+		Key:   key,
+		Value: value,
+	}
+}
+
+func parsePrimary(token lexer.Token, span ast.Span) ast.Expression {
+	switch token.Type {
+	case lexer.STRING:
+		return &ast.StringLiteral{Span: span, Value: token.Literal}
+	case lexer.IDENT:
+		return &ast.Identifier{Span: span, Name: token.Literal}
+	case lexer.KEYWORD:
+		switch token.Literal {
+		case "true":
+			return &ast.BooleanLiteral{Span: span, Value: true}
+		case "false":
+			return &ast.BooleanLiteral{Span: span, Value: false}
+		}
+	}
+	return nil
+}
+
+// parseNumber turns an integer or float token such as "42", "-3.14" or
+// "1e-3" into a literal, or returns nil if the token is not a number. A
+// leading digit is required so names like "inf" stay identifiers. An
+// integer too large for an int is reported against tok rather than read as
+// a float.
+func (ep *exprParser) parseNumber(tok lexer.Token, token string, span ast.Span) ast.Expression {
+	digits := strings.TrimPrefix(token, "-")
+	if digits == "" || digits[0] < '0' || digits[0] > '9' {
+		return nil
+	}
+	if !strings.ContainsAny(digits, ".eE") {
+		val, err := strconv.Atoi(token)
+		if err != nil {
+			ep.errorf(tok, "integer %s is out of range; integers run from %d to %d", token, math.MinInt, math.MaxInt)
+		}
+		return &ast.IntegerLiteral{Span: span, Value: val}
+	}
+	if val, err := strconv.ParseFloat(token, 64); err == nil {
+		return &ast.FloatLiteral{Span: span, Value: val}
+	}
+	return nil
+}
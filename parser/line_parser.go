@@ -1,37 +1,58 @@
 package parser
 
 import (
-	"strconv"
-	"strings"
+	"sort"
+
 	"github.com/mistium/raingoer/ast"
-	"github.com/mistium/raingoer/tokens"
+	"github.com/mistium/raingoer/lexer"
 )
 
+// LineParser parses a program one line at a time. A line is the tokens
+// between two newlines; the lexer already joins lines that sit inside open
+// brackets, braces or parentheses.
 type LineParser struct {
-	lines []string
+	lines [][]lexer.Token
 	pos   int
+	errs  errorList
 }
 
-func NewLineParser(input string) *LineParser {
-	lines := tokens.Tokenise(input, '\n')
-	var filteredLines []string
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line != "" {
-			filteredLines = append(filteredLines, line)
+// NewLineParser prepares input for parsing. filename is only used to label
+// parse errors and may be empty.
+func NewLineParser(filename, input string) *LineParser {
+	var lines [][]lexer.Token
+	var line []lexer.Token
+	errs := errorList{file: filename}
+
+	for _, tok := range lexer.Tokenize(input) {
+		switch tok.Type {
+		case lexer.COMMENT:
+			continue
+		case lexer.ILLEGAL:
+			errs.errorf(tok, "%s", tok.Err)
+			continue
+		case lexer.NEWLINE, lexer.EOF:
+			if len(line) > 0 {
+				lines = append(lines, line)
+				line = nil
+			}
+		default:
+			line = append(line, tok)
 		}
 	}
-	
+
 	return &LineParser{
-		lines: filteredLines,
+		lines: lines,
 		pos:   0,
+		errs:  errs,
 	}
 }
 
-func (lp *LineParser) Parse() *ast.Program {
+// Parse parses the whole program. Malformed statements are reported and
+// skipped so that every error in the file comes back at once; the program
+// should not be run unless the error list is empty.
+func (lp *LineParser) Parse() (*ast.Program, []*ParseError) {
 	program := &ast.Program{}
-	
+
 	for lp.pos < len(lp.lines) {
 		stmt := lp.parseStatement()
 		if stmt != nil {
@@ -39,694 +60,539 @@ func (lp *LineParser) Parse() *ast.Program {
 		}
 		lp.pos++
 	}
-	
-	return program
+
+	if len(program.Statements) > 0 {
+		program.Span = nodeSpan(program.Statements[0], program.Statements[len(program.Statements)-1])
+	}
+
+	// Lexer errors are collected up front, so put everything back in
+	// source order.
+	sort.SliceStable(lp.errs.errors, func(a, b int) bool {
+		ea, eb := lp.errs.errors[a], lp.errs.errors[b]
+		return ea.Line < eb.Line || (ea.Line == eb.Line && ea.Column < eb.Column)
+	})
+
+	return program, lp.errs.errors
 }
 
 func (lp *LineParser) parseStatement() ast.Statement {
 	if lp.pos >= len(lp.lines) {
 		return nil
 	}
-	
-	line := lp.lines[lp.pos]
-	tokens := lp.tokenizeLine(line)
-	
-	if len(tokens) == 0 { return nil }
-	if strings.HasPrefix(tokens[0], "//") {
+
+	tokens := lp.lines[lp.pos]
+	if tokens[0].Type == lexer.IDENT {
+		return statement(lp.parseFunctionCall(tokens))
+	}
+	if tokens[0].Type != lexer.KEYWORD {
+		lp.errs.errorf(tokens[0], "expected a statement, found %s", describe(tokens[0]))
 		return nil
 	}
 
-	switch tokens[0] {
-	case "func": return lp.parseFunctionDef()
-	case "set": return lp.parseSetStatement(tokens)
-	case "loop": return lp.parseLoopStatement(tokens)
-	case "while": return lp.parseWhileStatement(tokens)
-	case "switch": return lp.parseSwitchStatement(tokens)
-	case "if": return lp.parseIfStatement(tokens)
-	case "try": return lp.parseTryStatement()
-	case "return": return lp.parseReturnStatement(tokens)
-	default: return lp.parseFunctionCall(tokens)
+	switch tokens[0].Literal {
+	case "func": return statement(lp.parseFunctionDef(tokens))
+	case "set": return statement(lp.parseSetStatement(tokens))
+	case "loop": return statement(lp.parseLoopStatement(tokens))
+	case "while": return statement(lp.parseWhileStatement(tokens))
+	case "for": return statement(lp.parseForStatement(tokens))
+	case "switch": return statement(lp.parseSwitchStatement(tokens))
+	case "if": return statement(lp.parseIfStatement(tokens))
+	case "try": return statement(lp.parseTryStatement(tokens))
+	case "throw": return statement(lp.parseThrowStatement(tokens))
+	case "return": return statement(lp.parseReturnStatement(tokens))
+	case "break":
+		lp.expectLineEnd(tokens)
+		return &ast.BreakStatement{Span: lp.spanFrom(tokens[0])}
+	case "continue":
+		lp.expectLineEnd(tokens)
+		return &ast.ContinueStatement{Span: lp.spanFrom(tokens[0])}
+	default:
+		lp.errs.errorf(tokens[0], "unexpected %s", describe(tokens[0]))
+		return nil
 	}
 }
 
-func (lp *LineParser) tokenizeLine(line string) []string {
-	if commentIndex := strings.Index(line, "//"); commentIndex != -1 { line = line[:commentIndex] }
-	line = strings.TrimSpace(line)
-	
-	if line == "" { return []string{} }
-	
-	var result []string
-	var currentToken strings.Builder
-	inBrackets := 0
-	inBraces := 0
-	inString := false
-	
-	for _, char := range line {
-		if char == '"' && !inString {
-			inString = true
-			currentToken.WriteRune(char)
-		} else if char == '"' && inString {
-			inString = false
-			currentToken.WriteRune(char)
-		} else if inString {
-			currentToken.WriteRune(char)
-		} else {
-			switch char {
-			case '[':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, "[")
-				inBrackets++
-			case ']':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, "]")
-				inBrackets--
-			case '{':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, "{")
-				inBraces++
-			case '}':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, "}")
-				inBraces--
-			case ',':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, ",")
-			case ':':
-				if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				result = append(result, ":")
-			case ' ':
-				if (inBrackets > 0 || inBraces > 0) && currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				} else if currentToken.Len() > 0 {
-					result = append(result, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-			default:
-				currentToken.WriteRune(char)
-			}
-		}
-	}
-	
-	if currentToken.Len() > 0 {
-		result = append(result, strings.TrimSpace(currentToken.String()))
+// statement converts a parse result to an ast.Statement, keeping a nil
+// result nil rather than a non-nil interface holding a nil pointer.
+func statement[T any, P interface {
+	*T
+	ast.Statement
+}](node P) ast.Statement {
+	if node == nil {
+		return nil
 	}
+	return node
+}
 
-	var filtered []string
-	for _, token := range result {
-		if strings.TrimSpace(token) != "" {
-			filtered = append(filtered, strings.TrimSpace(token))
+// lineStartsWith reports whether the current line begins with one of the
+// given keywords.
+func (lp *LineParser) lineStartsWith(keywords ...string) bool {
+	if lp.pos >= len(lp.lines) {
+		return false
+	}
+	first := lp.lines[lp.pos][0]
+	for _, keyword := range keywords {
+		if first.Is(keyword) {
+			return true
 		}
 	}
-	
-	return filtered
+	return false
 }
 
-func (lp *LineParser) parseFunctionDef() *ast.FunctionDef {
+// parseBlock parses the lines after the current one up to the matching
+// "end" or a line starting with one of the extra terminators, leaving
+// lp.pos on that line.
+//
+// A block that runs off the end of the file is reported against the line
+// that opened it.
+func (lp *LineParser) parseBlock(terminators ...string) []ast.Statement {
 	var body []ast.Statement
+	opener := lp.lines[lp.pos][0]
 	lp.pos++
-	
-	line := lp.lines[lp.pos-1]
-	tokens := lp.tokenizeLine(line)
-	
-	name := tokens[1]
-	var params []string
-	for i := 2; i < len(tokens); i++ {
-		params = append(params, tokens[i])
-	}
 
-	for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
+	terminators = append(terminators, "end")
+	for lp.pos < len(lp.lines) && !lp.lineStartsWith(terminators...) {
 		stmt := lp.parseStatement()
 		if stmt != nil {
 			body = append(body, stmt)
 		}
 		lp.pos++
 	}
-	
-	if lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) == "end" {
 
+	if lp.pos >= len(lp.lines) {
+		lp.errs.errorf(opener, "block opened by %s on line %d is never closed with `end`", describe(opener), opener.Line)
+	} else if lp.lineStartsWith("end") {
+		lp.expectLineEnd(lp.lines[lp.pos])
+	}
+
+	return body
+}
+
+// expectLineEnd reports anything on line after the keyword it starts with.
+func (lp *LineParser) expectLineEnd(line []lexer.Token) {
+	if len(line) > 1 {
+		lp.errs.errorf(line[1], "unexpected %s after %s", describe(line[1]), describe(line[0]))
+	}
+}
+
+// spanFrom covers the source from first to the end of the current line.
+// Block statements call it once their closing "end" is the current line.
+func (lp *LineParser) spanFrom(first lexer.Token) ast.Span {
+	line := lp.lines[min(lp.pos, len(lp.lines)-1)]
+	return tokenSpan(lp.errs.file, first, line[len(line)-1])
+}
+
+func (lp *LineParser) expressionParser(tokens []lexer.Token) *exprParser {
+	return newExprParser(tokens, func() []ast.Statement { return lp.parseBlock() }, &lp.errs)
+}
+
+func (lp *LineParser) parseExpressionFromTokens(tokens []lexer.Token) ast.Expression {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	return lp.expressionParser(tokens).parseComplete()
+}
+
+// expectExpression parses the tokens after keyword as an expression,
+// reporting an error against keyword if there are none.
+func (lp *LineParser) expectExpression(keyword lexer.Token, tokens []lexer.Token, what string) ast.Expression {
+	if len(tokens) == 0 {
+		lp.errs.errorf(keyword, "expected %s after %s", what, describe(keyword))
+		return nil
 	}
-	
+
+	return lp.parseExpressionFromTokens(tokens)
+}
+
+// expectName reports tok unless it is a plain identifier.
+func (lp *LineParser) expectName(tok lexer.Token, what string) bool {
+	if tok.Type != lexer.IDENT {
+		lp.errs.errorf(tok, "expected %s, found %s", what, describe(tok))
+		return false
+	}
+	return true
+}
+
+func (lp *LineParser) parseFunctionDef(tokens []lexer.Token) *ast.FunctionDef {
+	valid := true
+	if len(tokens) < 2 {
+		lp.errs.errorf(tokens[0], "expected a function name after `func`")
+		valid = false
+	} else {
+		valid = lp.expectName(tokens[1], "a function name")
+	}
+
+	var params []string
+	if len(tokens) > 2 {
+		for _, tok := range tokens[2:] {
+			valid = lp.expectName(tok, "a parameter name") && valid
+			params = append(params, tok.Literal)
+		}
+	}
+
+	// The body is parsed even after an error so its "end" is matched.
+	body := lp.parseBlock()
+	if !valid {
+		return nil
+	}
+
 	return &ast.FunctionDef{
-		Name:       name,
+		Span:       lp.spanFrom(tokens[0]),
+		Name:       tokens[1].Literal,
 		Parameters: params,
 		Body:       body,
 	}
 }
 
-func (lp *LineParser) parseSetStatement(tokens []string) *ast.SetStatement {
-	if len(tokens) < 4 || tokens[2] != "to" {
+func (lp *LineParser) parseSetStatement(tokens []lexer.Token) *ast.SetStatement {
+	toIndex := -1
+	depth := 0
+	for idx := 1; idx < len(tokens) && toIndex == -1; idx++ {
+		switch {
+		case tokens[idx].Is("{"):
+			depth++
+		case tokens[idx].Is("}"):
+			depth--
+		case tokens[idx].Is("to") && depth == 0:
+			toIndex = idx
+		}
+	}
+	switch {
+	case len(tokens) < 2:
+		lp.errs.errorf(tokens[0], "expected a variable after `set`")
+		return nil
+	case toIndex == -1:
+		lp.errs.errorf(tokens[len(tokens)-1], "expected `to` in set statement")
+		return nil
+	case toIndex == 1:
+		lp.errs.errorf(tokens[1], "expected a variable before `to`")
+		return nil
+	case toIndex == len(tokens)-1:
+		lp.errs.errorf(tokens[toIndex], "expected a value after `to`")
+		return nil
+	}
+
+	// The target is parsed as an access chain like data{person}{age} and
+	// then flattened into the variable and the keys below it.
+	var path []ast.Expression
+	target := lp.parseExpressionFromTokens(tokens[1:toIndex])
+	for {
+		access, ok := target.(*ast.AccessExpression)
+		if !ok {
+			break
+		}
+		path = append([]ast.Expression{access.Key}, path...)
+		target = access.Object
+	}
+	variable, ok := target.(*ast.Identifier)
+	if !ok {
+		if target != nil {
+			lp.errs.errorf(tokens[1], "cannot assign to %s", describe(tokens[1]))
+		}
 		return nil
 	}
-	
-	variable := tokens[1]
-	value := lp.parseExpressionFromTokens(tokens[3:])
-	
+
+	value := lp.parseExpressionFromTokens(tokens[toIndex+1:])
+	if value == nil {
+		return nil
+	}
+
 	return &ast.SetStatement{
-		Variable: variable,
+		Span:     lp.spanFrom(tokens[0]),
+		Variable: variable.Name,
+		Path:     path,
 		Value:    value,
 	}
 }
 
-func (lp *LineParser) parseLoopStatement(tokens []string) *ast.LoopStatement {
-	if len(tokens) < 2 { return nil }
-
-	count := lp.parseExpressionFromTokens(tokens[1:2])
-	
-	var body []ast.Statement
-	lp.pos++
-
-	for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-		stmt := lp.parseStatement()
-		if stmt != nil { body = append(body, stmt) }
-		lp.pos++
+func (lp *LineParser) parseLoopStatement(tokens []lexer.Token) *ast.LoopStatement {
+	countTokens := tokens[1:]
+	counter := ""
+	if len(tokens) > 3 && tokens[len(tokens)-2].Is("as") {
+		countTokens = tokens[1 : len(tokens)-2]
+		counter = tokens[len(tokens)-1].Literal
+		lp.expectName(tokens[len(tokens)-1], "a counter name")
+	}
+	count := lp.expectExpression(tokens[0], countTokens, "a count")
+	body := lp.parseBlock()
+	if count == nil {
+		return nil
 	}
-	
-	if lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) == "end" {}
-	
+
 	return &ast.LoopStatement{
-		Count: count,
-		Body:  body,
+		Span:    lp.spanFrom(tokens[0]),
+		Count:   count,
+		Counter: counter,
+		Body:    body,
 	}
 }
 
-func (lp *LineParser) parseWhileStatement(tokens []string) *ast.WhileStatement {
-	if len(tokens) < 2 {
-		return nil
+// parseForStatement handles "for item in arr", "for key, value in obj" and
+// "for i in 0..10".
+func (lp *LineParser) parseForStatement(tokens []lexer.Token) *ast.ForStatement {
+	inIndex := -1
+	for idx, token := range tokens {
+		if token.Is("in") {
+			inIndex = idx
+			break
+		}
 	}
-	
-	condition := lp.parseExpressionFromTokens(tokens[1:])
-	
-	var body []ast.Statement
-	lp.pos++
-	
-	for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-		stmt := lp.parseStatement()
-		if stmt != nil {
-			body = append(body, stmt)
+
+	var key, value string
+	var iterable ast.Expression
+	if inIndex == -1 {
+		lp.errs.errorf(tokens[len(tokens)-1], "expected `in` in for loop")
+	} else {
+		switch names := tokens[1:inIndex]; {
+		case len(names) == 1:
+			if lp.expectName(names[0], "a loop variable") {
+				value = names[0].Literal
+			}
+		case len(names) == 3 && names[1].Is(","):
+			if lp.expectName(names[0], "a loop variable") && lp.expectName(names[2], "a loop variable") {
+				key, value = names[0].Literal, names[2].Literal
+			}
+		default:
+			lp.errs.errorf(tokens[0], "expected `for item in ...` or `for key, value in ...`")
 		}
-		lp.pos++
+		iterable = lp.expectExpression(tokens[inIndex], tokens[inIndex+1:], "something to iterate over")
 	}
-	
-	if lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) == "end" {
+
+	body := lp.parseBlock()
+	if iterable == nil || value == "" {
+		return nil
+	}
+
+	return &ast.ForStatement{
+		Span:     lp.spanFrom(tokens[0]),
+		Key:      key,
+		Value:    value,
+		Iterable: iterable,
+		Body:     body,
 	}
-	
+}
+
+func (lp *LineParser) parseWhileStatement(tokens []lexer.Token) *ast.WhileStatement {
+	condition := lp.expectExpression(tokens[0], tokens[1:], "a condition")
+	body := lp.parseBlock()
+	if condition == nil {
+		return nil
+	}
+
 	return &ast.WhileStatement{
+		Span:      lp.spanFrom(tokens[0]),
 		Condition: condition,
 		Body:      body,
 	}
 }
 
-func (lp *LineParser) parseSwitchStatement(tokens []string) *ast.SwitchStatement {
-	if len(tokens) < 2 {
-		return nil
-	}
-	
-	expression := lp.parseExpressionFromTokens(tokens[1:])
-	
+func (lp *LineParser) parseSwitchStatement(tokens []lexer.Token) *ast.SwitchStatement {
+	expression := lp.expectExpression(tokens[0], tokens[1:], "a value")
+
 	var cases []ast.CaseClause
 	var defaultCase []ast.Statement
+	// inClause is set while lp.pos is on the line that ended a clause's
+	// block, which has already checked it if it is the "end".
+	inClause := false
 	lp.pos++
-	
-	for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-		line := strings.TrimSpace(lp.lines[lp.pos])
-		lineTokens := lp.tokenizeLine(line)
-		
-		if len(lineTokens) == 0 {
-			lp.pos++
-			continue
-		}
-		
-		if lineTokens[0] == "case" {
+
+	// Each clause leaves lp.pos on the line that starts the next clause or
+	// on the switch's own "end".
+	for lp.pos < len(lp.lines) && !lp.lineStartsWith("end") {
+		lineTokens := lp.lines[lp.pos]
+
+		if lineTokens[0].Is("case") {
 			caseClause := lp.parseCaseClause(lineTokens)
 			if caseClause != nil {
 				cases = append(cases, *caseClause)
 			}
-		} else if lineTokens[0] == "default" {
-			lp.pos++
-			for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" && !strings.HasPrefix(strings.TrimSpace(lp.lines[lp.pos]), "case") {
-				stmt := lp.parseStatement()
-				if stmt != nil {
-					defaultCase = append(defaultCase, stmt)
-				}
-				lp.pos++
-			}
-			lp.pos-- // Back up one since the outer loop will increment
+			inClause = true
+		} else if lineTokens[0].Is("default") {
+			lp.expectLineEnd(lineTokens)
+			defaultCase = lp.parseBlock("case", "default")
+			inClause = true
 		} else {
+			lp.errs.errorf(lineTokens[0], "expected `case` or `default` inside switch, found %s", describe(lineTokens[0]))
 			lp.pos++
+			inClause = false
 		}
 	}
-	
+
+	if lp.pos >= len(lp.lines) {
+		lp.errs.errorf(tokens[0], "block opened by `switch` on line %d is never closed with `end`", tokens[0].Line)
+	} else if !inClause {
+		lp.expectLineEnd(lp.lines[lp.pos])
+	}
+	if expression == nil {
+		return nil
+	}
+
 	return &ast.SwitchStatement{
+		Span:       lp.spanFrom(tokens[0]),
 		Expression: expression,
 		Cases:      cases,
 		Default:    defaultCase,
 	}
 }
 
-func (lp *LineParser) parseCaseClause(tokens []string) *ast.CaseClause {
+func (lp *LineParser) parseCaseClause(tokens []lexer.Token) *ast.CaseClause {
 	if len(tokens) < 2 {
+		lp.errs.errorf(tokens[0], "expected a value after `case`")
+		lp.parseBlock("case", "default")
 		return nil
 	}
-	
+
 	// Parse case values (can be multiple separated by commas)
 	var values []ast.Expression
-	var currentValue []string
-	
-	for i := 1; i < len(tokens); i++ {
-		if tokens[i] == "," {
-			if len(currentValue) > 0 {
-				expr := lp.parseExpressionFromTokens(currentValue)
-				if expr != nil {
-					values = append(values, expr)
-				}
-				currentValue = nil
-			}
-		} else {
-			currentValue = append(currentValue, tokens[i])
-		}
-	}
-	
-	if len(currentValue) > 0 {
-		expr := lp.parseExpressionFromTokens(currentValue)
+	for _, valueTokens := range splitTopLevel(tokens[1:]) {
+		expr := lp.parseExpressionFromTokens(valueTokens)
 		if expr != nil {
 			values = append(values, expr)
 		}
 	}
-	
-	// Parse case body
-	var body []ast.Statement
-	lp.pos++
-	
-	for lp.pos < len(lp.lines) {
-		line := strings.TrimSpace(lp.lines[lp.pos])
-		if line == "end" || strings.HasPrefix(line, "case") || strings.HasPrefix(line, "default") {
-			lp.pos-- // Back up one since the caller will increment
-			break
-		}
-		
-		stmt := lp.parseStatement()
-		if stmt != nil {
-			body = append(body, stmt)
-		}
-		lp.pos++
-	}
-	
+
+	body := lp.parseBlock("case", "default")
+
 	return &ast.CaseClause{
+		Span:   lp.spanFrom(tokens[0]),
 		Values: values,
 		Body:   body,
 	}
 }
 
-func (lp *LineParser) parseIfStatement(tokens []string) *ast.IfStatement {
-	if len(tokens) < 2 {
-		return nil
-	}
-	
-	condition := lp.parseExpressionFromTokens(tokens[1:])
-	
-	var body []ast.Statement
-	lp.pos++
-	
-	for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-		stmt := lp.parseStatement()
-		if stmt != nil {
-			body = append(body, stmt)
-		}
-		lp.pos++
-	}
-	
-	if lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) == "end" {
-	}
-	
-	return &ast.IfStatement{
-		Condition: condition,
-		Body:      body,
+func (lp *LineParser) parseIfStatement(tokens []lexer.Token) *ast.IfStatement {
+	stmt := &ast.IfStatement{
+		Condition: lp.expectExpression(tokens[0], tokens[1:], "a condition"),
+		Body:      lp.parseBlock("else", "elif"),
 	}
-}
 
-func (lp *LineParser) parseTryStatement() *ast.TryStatement {
-	var tryBody []ast.Statement
-	var catchBody []ast.Statement
-	var errorVar string
-	
-	lp.pos++
-	for lp.pos < len(lp.lines) && !strings.HasPrefix(strings.TrimSpace(lp.lines[lp.pos]), "catch") && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-		stmt := lp.parseStatement()
-		if stmt != nil {
-			tryBody = append(tryBody, stmt)
-		}
-		lp.pos++
+	// Whichever way the chain below ends, lp.pos finishes on its "end".
+	defer func() { stmt.Span = lp.spanFrom(tokens[0]) }()
+
+	if lp.pos >= len(lp.lines) {
+		return stmt
 	}
-	if lp.pos < len(lp.lines) && strings.HasPrefix(strings.TrimSpace(lp.lines[lp.pos]), "catch") {
-		catchLine := strings.TrimSpace(lp.lines[lp.pos])
-		tokens := lp.tokenizeLine(catchLine)
-		if len(tokens) > 1 {
-			errorVar = tokens[1]
+
+	// An else-if shares the closing "end" of the whole chain, so the nested
+	// parse leaves lp.pos on that line for our caller.
+	lineTokens := lp.lines[lp.pos]
+	if lineTokens[0].Is("elif") || (lineTokens[0].Is("else") && len(lineTokens) > 1 && lineTokens[1].Is("if")) {
+		if lineTokens[0].Is("else") {
+			lineTokens = lineTokens[1:]
 		}
-		lp.pos++
-		for lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) != "end" {
-			stmt := lp.parseStatement()
-			if stmt != nil {
-				catchBody = append(catchBody, stmt)
-			}
-			lp.pos++
+		if elseIf := lp.parseIfStatement(lineTokens); elseIf != nil {
+			stmt.Alternative = []ast.Statement{elseIf}
 		}
+		return stmt
 	}
-	
-	if lp.pos < len(lp.lines) && strings.TrimSpace(lp.lines[lp.pos]) == "end" {
-	}
-	
-	return &ast.TryStatement{
-		TryBody:   tryBody,
-		CatchBody: catchBody,
-		ErrorVar:  errorVar,
-	}
-}
 
-func (lp *LineParser) parseReturnStatement(tokens []string) *ast.ReturnStatement {
-	if len(tokens) < 2 {
-		return nil
-	}
-	
-	value := lp.parseExpressionFromTokens(tokens[1:])
-	
-	return &ast.ReturnStatement{
-		Value: value,
+	if lineTokens[0].Is("else") {
+		lp.expectLineEnd(lineTokens)
+		alternative := lp.parseBlock()
+		if alternative == nil {
+			alternative = []ast.Statement{}
+		}
+		stmt.Alternative = alternative
 	}
+
+	return stmt
 }
 
-func (lp *LineParser) parseFunctionCall(tokens []string) *ast.FunctionCall {
-	if len(tokens) == 0 {
-		return nil
+func (lp *LineParser) parseTryStatement(tokens []lexer.Token) *ast.TryStatement {
+	var catches []ast.CatchClause
+	var finally []ast.Statement
+
+	// Each clause leaves lp.pos on the line that starts the next clause or
+	// on the try's own "end".
+	lp.expectLineEnd(tokens)
+	tryBody := lp.parseBlock("catch", "finally")
+	for lp.lineStartsWith("catch") {
+		catches = append(catches, lp.parseCatchClause(lp.lines[lp.pos]))
 	}
-	
-	name := tokens[0]
-	var args []ast.Expression
-	if len(tokens) > 1 {
-		arg := lp.parseExpressionFromTokens(tokens[1:])
-		if arg != nil {
-			args = append(args, arg)
-		}
+	if lp.lineStartsWith("finally") {
+		lp.expectLineEnd(lp.lines[lp.pos])
+		// An empty finally block is still a finally block.
+		finally = append([]ast.Statement{}, lp.parseBlock()...)
 	}
-	
-	return &ast.FunctionCall{
-		Name: name,
-		Args: args,
+
+	return &ast.TryStatement{
+		Span:    lp.spanFrom(tokens[0]),
+		TryBody: tryBody,
+		Catches: catches,
+		Finally: finally,
 	}
 }
 
-func (lp *LineParser) parseExpressionFromTokens(tokens []string) ast.Expression {
-	if len(tokens) == 0 {
-		return nil
+// parseCatchClause parses "catch", "catch e" or "catch e as Kind, Kind"
+// and the block after it.
+func (lp *LineParser) parseCatchClause(tokens []lexer.Token) ast.CatchClause {
+	var errorVar string
+	var kinds []string
+
+	if len(tokens) > 1 && lp.expectName(tokens[1], "an error variable") {
+		errorVar = tokens[1].Literal
 	}
-	
-	if len(tokens) == 1 {
-		return lp.parsePrimary(tokens[0])
-	}
-	for i := 0; i < len(tokens)-1; i++ {
-		if tokens[i+1] == "{" {
-			braceEnd := -1
-			braceDepth := 0
-			for j := i + 1; j < len(tokens); j++ {
-				if tokens[j] == "{" {
-					braceDepth++
-				} else if tokens[j] == "}" {
-					braceDepth--
-					if braceDepth == 0 {
-						braceEnd = j
+	if len(tokens) > 2 {
+		if !tokens[2].Is("as") {
+			lp.errs.errorf(tokens[2], "expected `as` after the error variable, found %s", describe(tokens[2]))
+		} else if len(tokens) == 3 || tokens[len(tokens)-1].Is(",") {
+			lp.errs.errorf(tokens[len(tokens)-1], "expected an error kind after %s", describe(tokens[len(tokens)-1]))
+		} else {
+			for idx, tok := range tokens[3:] {
+				if idx%2 == 1 {
+					if !tok.Is(",") {
+						lp.errs.errorf(tok, "expected `,` between error kinds, found %s", describe(tok))
 						break
 					}
+					continue
 				}
-			}
-			
-			if braceEnd > i + 2 {
-				objectTokens := tokens[:i+1]
-				keyTokens := tokens[i+2:braceEnd]
-				
-				object := lp.parseExpressionFromTokens(objectTokens)
-				key := lp.parseExpressionFromTokens(keyTokens)
-				
-				return &ast.AccessExpression{
-					Object: object,
-					Key:    key,
+				if !lp.expectName(tok, "an error kind") {
+					break
 				}
+				kinds = append(kinds, tok.Literal)
 			}
 		}
 	}
 
-	for i := 1; i < len(tokens); i++ {
-		if isOperator(tokens[i]) {
-			left := lp.parseExpressionFromTokens(tokens[:i])
-			right := lp.parseExpressionFromTokens(tokens[i+1:])
-			return &ast.BinaryExpression{
-				Left:     left,
-				Operator: tokens[i],
-				Right:    right,
-			}
-		}
-	}
-
-	if tokens[0] == "[" {
-		bracketEnd := -1
-		for i := 1; i < len(tokens); i++ {
-			if tokens[i] == "]" {
-				bracketEnd = i
-				break
-			}
-		}
-		
-		if bracketEnd > 1 {
-			innerTokens := tokens[1:bracketEnd]
-			if len(innerTokens) > 0 {
-				name := innerTokens[0]
-				var args []ast.Expression
-				for i := 1; i < len(innerTokens); i++ {
-					arg := lp.parsePrimary(innerTokens[i])
-					if arg != nil {
-						args = append(args, arg)
-					}
-				}
-				
-				functionCall := &ast.FunctionCall{
-					Name: name,
-					Args: args,
-				}
-				
-				return &ast.BracketExpression{Expression: functionCall}
-			}
-		}
-	}
+	body := lp.parseBlock("catch", "finally")
 
-	if tokens[0] == "{" {
-		return lp.parseArrayOrObject(tokens)
+	return ast.CatchClause{
+		Span:     lp.spanFrom(tokens[0]),
+		ErrorVar: errorVar,
+		Kinds:    kinds,
+		Body:     body,
 	}
-	
-	return lp.parsePrimary(tokens[0])
 }
 
-func (lp *LineParser) parseArrayOrObject(tokens []string) ast.Expression {
-	if len(tokens) < 2 || tokens[0] != "{" {
+func (lp *LineParser) parseThrowStatement(tokens []lexer.Token) *ast.ThrowStatement {
+	value := lp.expectExpression(tokens[0], tokens[1:], "a value")
+	if value == nil {
 		return nil
 	}
-	
-	braceEnd := -1
-	for i := 1; i < len(tokens); i++ {
-		if tokens[i] == "}" {
-			braceEnd = i
-			break
-		}
-	}
-	
-	if braceEnd == -1 {
-		return nil
-	}
-	
-	innerTokens := tokens[1:braceEnd]
-	if len(innerTokens) == 0 {
-		return &ast.ArrayLiteral{Elements: []ast.Expression{}}
-	}
-	
-	hasColon := false
-	for _, token := range innerTokens {
-		if token == ":" {
-			hasColon = true
-			break
-		}
-	}
-	
-	if hasColon {
-		return lp.parseObject(innerTokens)
-	} else {
-		return lp.parseArray(innerTokens)
-	}
-}
-
-func (lp *LineParser) parseArray(tokens []string) *ast.ArrayLiteral {
-	   var elements []ast.Expression
-	   var currentElement []string
-	   depth := 0
-	   for _, token := range tokens {
-			   if token == "," && depth == 0 {
-					   if len(currentElement) > 0 {
-							   expr := lp.parseExpressionFromTokens(currentElement)
-							   if expr != nil {
-									   elements = append(elements, expr)
-							   }
-							   currentElement = nil
-					   }
-			   } else {
-					   if token == "{" {
-							   depth++
-					   } else if token == "}" {
-							   depth--
-					   }
-					   currentElement = append(currentElement, token)
-			   }
-	   }
-	   if len(currentElement) > 0 {
-			   expr := lp.parseExpressionFromTokens(currentElement)
-			   if expr != nil {
-					   elements = append(elements, expr)
-			   }
-	   }
-	   filtered := make([]ast.Expression, 0, len(elements))
-	   for _, e := range elements {
-			   if e != nil {
-					   filtered = append(filtered, e)
-			   }
-	   }
-	   return &ast.ArrayLiteral{Elements: filtered}
-}
-
-func (lp *LineParser) parseObject(tokens []string) *ast.ObjectLiteral {
-	   var properties []ast.ObjectProperty
-	   var currentProp []string
-	   depth := 0
-	   for _, token := range tokens {
-			   if token == "," && depth == 0 {
-					   if len(currentProp) > 0 {
-							   prop := lp.parseObjectProperty(currentProp)
-							   if prop != nil {
-									   properties = append(properties, *prop)
-							   }
-							   currentProp = nil
-					   }
-			   } else {
-					   if token == "{" {
-							   depth++
-					   } else if token == "}" {
-							   depth--
-					   }
-					   currentProp = append(currentProp, token)
-			   }
-	   }
-	   if len(currentProp) > 0 {
-			   prop := lp.parseObjectProperty(currentProp)
-			   if prop != nil {
-					   properties = append(properties, *prop)
-			   }
-	   }
-	   filtered := make([]ast.ObjectProperty, 0, len(properties))
-	   for _, p := range properties {
-			   if p.Value != nil {
-					   filtered = append(filtered, p)
-			   }
-	   }
-	   return &ast.ObjectLiteral{Properties: filtered}
-}
-
-func (lp *LineParser) parseObjectProperty(tokens []string) *ast.ObjectProperty {
-	   colonIndex := -1
-	   for i, token := range tokens {
-			   if token == ":" {
-					   colonIndex = i
-					   break
-			   }
-	   }
-	   if colonIndex == -1 || colonIndex == 0 || colonIndex == len(tokens)-1 {
-			   return nil
-	   }
-	   key := tokens[0]
-	   valueTokens := tokens[colonIndex+1:]
-	   // If value starts with {, group tokens until matching },
-	   // If value starts with [, group tokens until matching ]
-	   if len(valueTokens) > 0 && (valueTokens[0] == "{" || valueTokens[0] == "[") {
-			   open, close := valueTokens[0], "}"
-			   if open == "[" {
-					   close = "]"
-			   }
-			   depth := 0
-			   end := 0
-			   for i, t := range valueTokens {
-					   if t == open {
-							   depth++
-					   } else if t == close {
-							   depth--
-							   if depth == 0 {
-									   end = i + 1
-									   break
-							   }
-					   }
-			   }
-			   valueTokens = valueTokens[:end]
-	   }
-	   var value ast.Expression
-	   if len(valueTokens) == 1 {
-			   token := valueTokens[0]
-			   if val, err := strconv.Atoi(token); err == nil {
-					   value = &ast.IntegerLiteral{Value: val}
-			   } else if strings.HasPrefix(token, "\"") && strings.HasSuffix(token, "\"") {
-					   value = &ast.StringLiteral{Value: strings.Trim(token, "\"")}
-			   } else {
-					   value = &ast.StringLiteral{Value: token}
-			   }
-	   } else {
-			   value = lp.parseExpressionFromTokens(valueTokens)
-	   }
-	   if value == nil {
-			   return nil
-	   }
-	   return &ast.ObjectProperty{
-			   Key:   key,
-			   Value: value,
-	   }
-}
 
-func (lp *LineParser) parsePrimary(token string) ast.Expression {
-	if val, err := strconv.Atoi(token); err == nil {
-		return &ast.IntegerLiteral{Value: val}
+	return &ast.ThrowStatement{
+		Span:  lp.spanFrom(tokens[0]),
+		Value: value,
 	}
+}
 
-	if token == "true" {
-		return &ast.BooleanLiteral{Value: true}
+func (lp *LineParser) parseReturnStatement(tokens []lexer.Token) *ast.ReturnStatement {
+	value := lp.expectExpression(tokens[0], tokens[1:], "a value")
+	if value == nil {
+		return nil
 	}
 
-	if token == "false" {
-		return &ast.BooleanLiteral{Value: false}
+	return &ast.ReturnStatement{
+		Span:  lp.spanFrom(tokens[0]),
+		Value: value,
 	}
+}
 
-	if strings.HasPrefix(token, "\"") && strings.HasSuffix(token, "\"") {
-		return &ast.StringLiteral{Value: strings.Trim(token, "\"")}
+func (lp *LineParser) parseFunctionCall(tokens []lexer.Token) *ast.FunctionCall {
+	return &ast.FunctionCall{
+		Span: lp.spanFrom(tokens[0]),
+		Name: tokens[0].Literal,
+		Args: lp.expressionParser(nil).parseArguments(tokens[1:]),
 	}
-
-	return &ast.Identifier{Name: token}
 }
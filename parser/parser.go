@@ -1,92 +1,44 @@
 package parser
 
 import (
-	"strconv"
-	"strings"
 	"github.com/mistium/raingoer/ast"
-	"github.com/mistium/raingoer/tokens"
+	"github.com/mistium/raingoer/lexer"
 )
 
 type Parser struct {
-	tokens []string
+	tokens []lexer.Token
 	pos    int
 }
 
-func (p *Parser) DebugTokens() []string {
+func (p *Parser) DebugTokens() []lexer.Token {
 	return p.tokens
 }
 
 func New(input string) *Parser {
-	lines := tokens.Tokenise(input, '\n')
-	var allTokens []string
-	
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	var tokens []lexer.Token
+	for _, tok := range lexer.Tokenize(input) {
+		if tok.Type == lexer.COMMENT || tok.Type == lexer.EOF {
 			continue
 		}
-
-		var currentToken strings.Builder
-		inBrackets := 0
-		
-		for i, char := range line {
-			switch char {
-			case '[':
-				if currentToken.Len() > 0 {
-					allTokens = append(allTokens, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				allTokens = append(allTokens, "[")
-				inBrackets++
-			case ']':
-				if currentToken.Len() > 0 {
-					allTokens = append(allTokens, strings.TrimSpace(currentToken.String()))
-					currentToken.Reset()
-				}
-				allTokens = append(allTokens, "]")
-				inBrackets--
-			case ' ':
-				if inBrackets > 0 || currentToken.Len() > 0 {
-					if currentToken.Len() > 0 {
-						allTokens = append(allTokens, strings.TrimSpace(currentToken.String()))
-						currentToken.Reset()
-					}
-				}
-			default:
-				currentToken.WriteRune(char)
-			}
-
-			if i == len(line)-1 && currentToken.Len() > 0 {
-				allTokens = append(allTokens, strings.TrimSpace(currentToken.String()))
-			}
-		}
-
-		allTokens = append(allTokens, "\n")
+		tokens = append(tokens, tok)
 	}
 
-	var filteredTokens []string
-	for _, token := range allTokens {
-		if strings.TrimSpace(token) != "" {
-			filteredTokens = append(filteredTokens, token)
-		}
-	}
-	
 	return &Parser{
-		tokens: filteredTokens,
+		tokens: tokens,
 		pos:    0,
 	}
 }
 
-func (p *Parser) currentToken() string {
+func (p *Parser) currentToken() lexer.Token {
 	if p.pos >= len(p.tokens) {
-		return ""
+		return lexer.Token{Type: lexer.EOF}
 	}
 	return p.tokens[p.pos]
 }
 
-func (p *Parser) peekToken() string {
+func (p *Parser) peekToken() lexer.Token {
 	if p.pos+1 >= len(p.tokens) {
-		return ""
+		return lexer.Token{Type: lexer.EOF}
 	}
 	return p.tokens[p.pos+1]
 }
@@ -95,12 +47,28 @@ func (p *Parser) nextToken() {
 	p.pos++
 }
 
+// atLineEnd reports whether the current token ends a statement.
+func (p *Parser) atLineEnd() bool {
+	tok := p.currentToken()
+	return tok.Type == lexer.NEWLINE || tok.Type == lexer.EOF
+}
+
+// spanFrom covers the tokens from start up to the last one consumed,
+// leaving out trailing newlines.
+func (p *Parser) spanFrom(start int) ast.Span {
+	last := p.pos - 1
+	for last > start && p.tokens[last].Type == lexer.NEWLINE {
+		last--
+	}
+	return tokenSpan("", p.tokens[start], p.tokens[last])
+}
+
 func (p *Parser) Parse() *ast.Program {
 	program := &ast.Program{}
 	
 	for p.pos < len(p.tokens) {
 
-		if p.currentToken() == "\n" {
+		if p.atLineEnd() {
 			p.nextToken()
 			continue
 		}
@@ -117,18 +85,18 @@ func (p *Parser) Parse() *ast.Program {
 func (p *Parser) parseStatement() ast.Statement {
 	startPos := p.pos
 	
-	switch p.currentToken() {
-	case "func":
+	switch {
+	case p.currentToken().Is("func"):
 		return p.parseFunctionDef()
-	case "set":
+	case p.currentToken().Is("set"):
 		return p.parseSetStatement()
-	case "loop":
+	case p.currentToken().Is("loop"):
 		return p.parseLoopStatement()
-	case "return":
+	case p.currentToken().Is("return"):
 		return p.parseReturnStatement()
 	default:
 
-		if p.peekToken() != "" && !isOperator(p.peekToken()) {
+		if p.currentToken().Type == lexer.IDENT && !isBinaryOperator(p.peekToken()) {
 			return p.parseFunctionCall()
 		}
 
@@ -141,196 +109,139 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
-func (p *Parser) parseFunctionDef() *ast.FunctionDef {
-	p.nextToken()
-	
-	name := p.currentToken()
-	p.nextToken()
-	
-	var params []string
-
-	for p.currentToken() != "" && !isKeyword(p.currentToken()) {
-		params = append(params, p.currentToken())
-		p.nextToken()
-	}
-	
+// parseBody parses statements up to the matching "end" and steps past it.
+func (p *Parser) parseBody() []ast.Statement {
 	var body []ast.Statement
-	for p.currentToken() != "end" && p.pos < len(p.tokens) {
+	for !p.currentToken().Is("end") && p.pos < len(p.tokens) {
+		if p.atLineEnd() {
+			p.nextToken()
+			continue
+		}
 		stmt := p.parseStatement()
 		if stmt != nil {
 			body = append(body, stmt)
 		}
 	}
 	
-	if p.currentToken() == "end" {
+	if p.currentToken().Is("end") {
+		p.nextToken()
+	}
+	
+	return body
+}
+
+func (p *Parser) parseFunctionDef() *ast.FunctionDef {
+	start := p.pos
+	p.nextToken()
+	
+	name := p.currentToken().Literal
+	p.nextToken()
+	
+	var params []string
+
+	for !p.atLineEnd() {
+		params = append(params, p.currentToken().Literal)
 		p.nextToken()
 	}
 	
 	return &ast.FunctionDef{
+		Span:       p.spanFrom(start),
 		Name:       name,
 		Parameters: params,
-		Body:       body,
+		Body:       p.parseBody(),
 	}
 }
 
 func (p *Parser) parseSetStatement() *ast.SetStatement {
+	start := p.pos
 	p.nextToken()
 	
-	variable := p.currentToken()
+	variable := p.currentToken().Literal
 	p.nextToken()
 	
-	if p.currentToken() == "to" {
+	if p.currentToken().Is("to") {
 		p.nextToken()
 	}
 	
 	value := p.parseExpression()
 	
 	return &ast.SetStatement{
+		Span:     p.spanFrom(start),
 		Variable: variable,
 		Value:    value,
 	}
 }
 
 func (p *Parser) parseLoopStatement() *ast.LoopStatement {
+	start := p.pos
 	p.nextToken()
 	
 	count := p.parseExpression()
 	
-	var body []ast.Statement
-	for p.currentToken() != "end" && p.pos < len(p.tokens) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			body = append(body, stmt)
-		}
-	}
-	
-	if p.currentToken() == "end" {
-		p.nextToken()
-	}
-	
 	return &ast.LoopStatement{
+		Span:  p.spanFrom(start),
 		Count: count,
-		Body:  body,
+		Body:  p.parseBody(),
 	}
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
+	start := p.pos
 	p.nextToken()
 	
 	value := p.parseExpression()
 	
 	return &ast.ReturnStatement{
+		Span:  p.spanFrom(start),
 		Value: value,
 	}
 }
 
 func (p *Parser) parseFunctionCall() *ast.FunctionCall {
-	name := p.currentToken()
+	start := p.pos
+	name := p.currentToken().Literal
 	p.nextToken()
 	
 	var args []ast.Expression
 
-	for p.currentToken() != "" && !isKeyword(p.currentToken()) && p.currentToken() != "\n" {
+	for !p.atLineEnd() && p.currentToken().Type != lexer.KEYWORD {
+		start := p.pos
 		arg := p.parseExpression()
 		if arg != nil {
 			args = append(args, arg)
 		}
 
-		if p.pos >= len(p.tokens) || p.currentToken() == "\n" {
-			break
+		if p.pos == start {
+			p.nextToken()
 		}
 	}
 	
 	return &ast.FunctionCall{
+		Span: p.spanFrom(start),
 		Name: name,
 		Args: args,
 	}
 }
 
+// parseExpression parses one expression from the rest of the current line
+// with the shared expression parser. A block function literal continues on
+// the following lines, so its body is read from the token stream here.
 func (p *Parser) parseExpression() ast.Expression {
-	left := p.parsePrimary()
-
-	if isOperator(p.currentToken()) {
-		operator := p.currentToken()
-		p.nextToken()
-		right := p.parseExpression()
-		
-		return &ast.BinaryExpression{
-			Left:     left,
-			Operator: operator,
-			Right:    right,
-		}
+	end := p.pos
+	for end < len(p.tokens) && p.tokens[end].Type != lexer.NEWLINE {
+		end++
 	}
-	
-	return left
-}
-
-func (p *Parser) parsePrimary() ast.Expression {
-	token := p.currentToken()
 
-	if token == "[" {
-		p.nextToken()
-
-		if p.currentToken() != "]" {
-			funcName := p.currentToken()
-			p.nextToken()
-			
-			var args []ast.Expression
-
-			for p.currentToken() != "]" && p.pos < len(p.tokens) {
-				arg := p.parsePrimary()
-				if arg != nil {
-					args = append(args, arg)
-				}
-			}
-			
-			if p.currentToken() == "]" {
-				p.nextToken()
-			}
-			
-			functionCall := &ast.FunctionCall{
-				Name: funcName,
-				Args: args,
-			}
-			
-			return &ast.BracketExpression{Expression: functionCall}
-		}
-		
-		if p.currentToken() == "]" {
-			p.nextToken()
-		}
-		return &ast.BracketExpression{Expression: &ast.IntegerLiteral{Value: 0}}
+	inBlock := false
+	ep := newExprParser(p.tokens[p.pos:end], func() []ast.Statement {
+		inBlock = true
+		p.pos = end
+		return p.parseBody()
+	}, nil)
+	expr := ep.parseExpression(precLowest)
+	if !inBlock {
+		p.pos += ep.pos
 	}
 	
-	p.nextToken()
-
-	if val, err := strconv.Atoi(token); err == nil {
-		return &ast.IntegerLiteral{Value: val}
-	}
-
-	if strings.HasPrefix(token, "\"") && strings.HasSuffix(token, "\"") {
-		return &ast.StringLiteral{Value: strings.Trim(token, "\"")}
-	}
-
-	return &ast.Identifier{Name: token}
-}
-
-func isKeyword(token string) bool {
-	keywords := []string{"func", "end", "set", "to", "loop", "return"}
-	for _, keyword := range keywords {
-		if token == keyword {
-			return true
-		}
-	}
-	return false
-}
-
-func isOperator(token string) bool {
-	operators := []string{"+", "-", "*", "/", "==", "!=", "<", ">", "<=", ">=", "++"}
-	for _, op := range operators {
-		if token == op {
-			return true
-		}
-	}
-	return false
+	return expr
 }
@@ -0,0 +1,19 @@
+package parser
+
+import (
+	"github.com/mistium/raingoer/ast"
+	"github.com/mistium/raingoer/lexer"
+)
+
+// tokenSpan covers the source from the start of first to the end of last.
+func tokenSpan(file string, first, last lexer.Token) ast.Span {
+	return ast.Span{
+		Start: ast.Position{File: file, Line: first.Line, Column: first.Column},
+		End:   ast.Position{File: file, Line: last.EndLine, Column: last.EndColumn},
+	}
+}
+
+// nodeSpan covers the source from the start of first to the end of last.
+func nodeSpan(first, last ast.Node) ast.Span {
+	return ast.Span{Start: first.Pos(), End: last.EndPos()}
+}